@@ -0,0 +1,169 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"go-uploader/pkg/telegram_api"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// CircuitBreaker stops sending requests to a bot that keeps failing.
+// After the cooldown one probe request is let through (half-open);
+// its outcome closes the breaker again or re-opens it.
+type CircuitBreaker struct {
+	mu                  sync.Mutex
+	state               string
+	consecutiveFailures int
+	openUntil           time.Time
+	probeStarted        time.Time
+	lastError           string
+}
+
+// getBreakerThreshold reads BOT_BREAKER_FAILURES (default 5)
+func getBreakerThreshold() int {
+	if v := os.Getenv("BOT_BREAKER_FAILURES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 5
+}
+
+// getBreakerCooldown reads BOT_BREAKER_COOLDOWN (default 30s)
+func getBreakerCooldown() time.Duration {
+	if v := os.Getenv("BOT_BREAKER_COOLDOWN"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return 30 * time.Second
+}
+
+// Allow reports whether a request may be sent to the bot.
+// An open breaker past its cooldown turns half-open and allows a single probe.
+func (cb *CircuitBreaker) Allow() bool {
+	if cb == nil {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	now := time.Now()
+	switch cb.state {
+	case CircuitOpen:
+		if now.Before(cb.openUntil) {
+			return false
+		}
+		cb.state = CircuitHalfOpen
+		cb.probeStarted = now
+		return true
+	case CircuitHalfOpen:
+		// اگه probe قبلی جواب نداد (مثلا بات انتخاب نشد) یکی دیگه بفرست
+		if now.Sub(cb.probeStarted) < getBreakerCooldown() {
+			return false
+		}
+		cb.probeStarted = now
+		return true
+	default:
+		return true
+	}
+}
+
+// IsOpen reports whether the breaker currently refuses requests. Unlike Allow it never
+// changes the state, so it is safe for picking or filtering bots; call Allow right before
+// a real attempt and record that attempt's outcome with NamedBot.Begin.
+func (cb *CircuitBreaker) IsOpen() bool {
+	if cb == nil {
+		return false
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	now := time.Now()
+	switch cb.state {
+	case CircuitOpen:
+		return now.Before(cb.openUntil)
+	case CircuitHalfOpen:
+		// probe در جریانه
+		return now.Sub(cb.probeStarted) < getBreakerCooldown()
+	default:
+		return false
+	}
+}
+
+// Record feeds the outcome of a request to the breaker
+func (cb *CircuitBreaker) Record(err error) {
+	if cb == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if err == nil {
+		cb.state = CircuitClosed
+		cb.consecutiveFailures = 0
+		cb.lastError = ""
+		return
+	}
+
+	cb.consecutiveFailures++
+	cb.lastError = err.Error()
+
+	cooldown := getBreakerCooldown()
+	trip := cb.state == CircuitHalfOpen || cb.consecutiveFailures >= getBreakerThreshold()
+
+	var apiErr *telegram_api.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.IsUnauthorized() {
+			trip = true
+		}
+		if apiErr.IsRateLimited() {
+			trip = true
+			if retryAfter := time.Duration(apiErr.RetryAfter) * time.Second; retryAfter > cooldown {
+				cooldown = retryAfter
+			}
+		}
+	}
+
+	if trip {
+		cb.state = CircuitOpen
+		cb.openUntil = time.Now().Add(cooldown)
+	}
+}
+
+// State returns the breaker state and, when open, until when
+func (cb *CircuitBreaker) State() (string, time.Time) {
+	if cb == nil {
+		return CircuitClosed, time.Time{}
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == "" {
+		return CircuitClosed, time.Time{}
+	}
+	return cb.state, cb.openUntil
+}
+
+// Begin marks a request to the bot as started and returns the function that
+// records its outcome in the bot's statistics and circuit breaker
+func (nb NamedBot) Begin() func(err error) {
+	doneStats := nb.Stats.Begin()
+	return func(err error) {
+		doneStats(err)
+		nb.Breaker.Record(err)
+	}
+}
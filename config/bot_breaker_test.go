@@ -0,0 +1,101 @@
+package config
+
+import (
+	"errors"
+	"go-uploader/pkg/telegram_api"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	t.Setenv("BOT_BREAKER_FAILURES", "2")
+	t.Setenv("BOT_BREAKER_COOLDOWN", "50ms")
+	failure := errors.New("boom")
+
+	type step struct {
+		name   string
+		do     func(t *testing.T, cb *CircuitBreaker)
+		state  string
+		isOpen bool
+	}
+	allow := func(want bool) func(t *testing.T, cb *CircuitBreaker) {
+		return func(t *testing.T, cb *CircuitBreaker) {
+			if got := cb.Allow(); got != want {
+				t.Fatalf("Allow() = %v, want %v", got, want)
+			}
+		}
+	}
+	record := func(err error) func(t *testing.T, cb *CircuitBreaker) {
+		return func(t *testing.T, cb *CircuitBreaker) { cb.Record(err) }
+	}
+	wait := func(t *testing.T, cb *CircuitBreaker) { time.Sleep(60 * time.Millisecond) }
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "failures below the threshold keep it closed",
+			steps: []step{
+				{"fresh", allow(true), CircuitClosed, false},
+				{"one failure", record(failure), CircuitClosed, false},
+				{"success resets", record(nil), CircuitClosed, false},
+				{"one more failure", record(failure), CircuitClosed, false},
+			},
+		},
+		{
+			name: "open, half-open probe succeeds, closed",
+			steps: []step{
+				{"failure", record(failure), CircuitClosed, false},
+				{"threshold", record(failure), CircuitOpen, true},
+				{"refused while cooling down", allow(false), CircuitOpen, true},
+				{"cooldown passed", wait, CircuitOpen, false},
+				{"probe", allow(true), CircuitHalfOpen, true},
+				{"only one probe", allow(false), CircuitHalfOpen, true},
+				{"probe succeeded", record(nil), CircuitClosed, false},
+			},
+		},
+		{
+			name: "half-open probe fails and re-opens",
+			steps: []step{
+				{"failure", record(failure), CircuitClosed, false},
+				{"threshold", record(failure), CircuitOpen, true},
+				{"cooldown passed", wait, CircuitOpen, false},
+				{"probe", allow(true), CircuitHalfOpen, true},
+				{"probe failed", record(failure), CircuitOpen, true},
+				{"refused again", allow(false), CircuitOpen, true},
+			},
+		},
+		{
+			name: "unauthorized opens at once",
+			steps: []step{
+				{"401", record(&telegram_api.APIError{StatusCode: 401}), CircuitOpen, true},
+			},
+		},
+		{
+			name: "IsOpen does not start a probe",
+			steps: []step{
+				{"failure", record(failure), CircuitClosed, false},
+				{"threshold", record(failure), CircuitOpen, true},
+				{"cooldown passed", wait, CircuitOpen, false},
+				{"checked", func(t *testing.T, cb *CircuitBreaker) { cb.IsOpen() }, CircuitOpen, false},
+				{"probe still available", allow(true), CircuitHalfOpen, true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := &CircuitBreaker{}
+			for _, s := range tt.steps {
+				s.do(t, cb)
+				if state, _ := cb.State(); state != s.state {
+					t.Fatalf("%s: state = %s, want %s", s.name, state, s.state)
+				}
+				if got := cb.IsOpen(); got != s.isOpen {
+					t.Fatalf("%s: IsOpen() = %v, want %v", s.name, got, s.isOpen)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-uploader/pkg/telegram_api"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BotIdentity holds what getMe reported about a bot token
+type BotIdentity struct {
+	mu        sync.RWMutex
+	id        int64
+	username  string
+	verified  bool
+	disabled  bool
+	reason    string
+	checkedAt time.Time
+}
+
+// BotStatus is a token-free view of a named bot for listings
+type BotStatus struct {
+	Name      string     `json:"name"`
+	Id        int64      `json:"id,omitempty"`
+	Username  string     `json:"username,omitempty"`
+	Verified  bool       `json:"verified"`
+	Enabled   bool       `json:"enabled"`
+	Reason    string     `json:"reason,omitempty"`
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+	Circuit   string     `json:"circuit"`
+	OpenUntil *time.Time `json:"openUntil,omitempty"`
+}
+
+// IsDisabled reports whether the bot was disabled by validation
+func (bi *BotIdentity) IsDisabled() bool {
+	if bi == nil {
+		return false
+	}
+	bi.mu.RLock()
+	defer bi.mu.RUnlock()
+	return bi.disabled
+}
+
+func (bi *BotIdentity) setVerified(user *telegram_api.BotUser) {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+	bi.id = user.Id
+	bi.username = user.Username
+	bi.verified = true
+	bi.disabled = false
+	bi.reason = ""
+	bi.checkedAt = time.Now()
+}
+
+func (bi *BotIdentity) setFailed(reason string, disable bool) {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+	bi.verified = false
+	bi.disabled = disable
+	bi.reason = reason
+	bi.checkedAt = time.Now()
+}
+
+// Status returns the current identity of the bot without exposing its token
+func (nb NamedBot) Status() BotStatus {
+	status := BotStatus{Name: nb.Name, Enabled: !nb.Disabled}
+
+	circuit, openUntil := nb.Breaker.State()
+	status.Circuit = circuit
+	if circuit == CircuitOpen {
+		status.OpenUntil = &openUntil
+	}
+
+	if nb.Identity == nil {
+		return status
+	}
+
+	nb.Identity.mu.RLock()
+	defer nb.Identity.mu.RUnlock()
+	status.Id = nb.Identity.id
+	status.Username = nb.Identity.username
+	status.Verified = nb.Identity.verified
+	status.Enabled = !nb.Disabled && !nb.Identity.disabled
+	status.Reason = nb.Identity.reason
+	if nb.Disabled {
+		status.Reason = "disabled by admin"
+	}
+	if !nb.Identity.checkedAt.IsZero() {
+		checkedAt := nb.Identity.checkedAt
+		status.CheckedAt = &checkedAt
+	}
+	return status
+}
+
+// Enabled reports whether the bot may be used for requests
+func (nb NamedBot) Enabled() bool {
+	return !nb.Disabled && !nb.Identity.IsDisabled()
+}
+
+// Validate calls getMe and records the identity; rejected tokens get disabled
+func (nb NamedBot) Validate(ctx context.Context) error {
+	if nb.Identity == nil {
+		return fmt.Errorf("bot '%s' has no identity", nb.Name)
+	}
+
+	user, err := nb.API.GetMe(ctx)
+	if err != nil {
+		var apiErr *telegram_api.APIError
+		if errors.As(err, &apiErr) && apiErr.IsUnauthorized() {
+			nb.Identity.setFailed("invalid token: "+apiErr.Description, true)
+			log.Printf("❌ Bot '%s' disabled: %s", nb.Name, apiErr.Description)
+			return fmt.Errorf("bot '%s': invalid token: %s", nb.Name, apiErr.Description)
+		}
+
+		// خطای شبکه یعنی توکن رو نمیشه رد کرد، فعال می‌مونه
+		nb.Identity.setFailed("unverified: "+err.Error(), false)
+		log.Printf("⚠️ Bot '%s' could not be verified: %v", nb.Name, err)
+		return fmt.Errorf("bot '%s': %w", nb.Name, err)
+	}
+
+	nb.Identity.setVerified(user)
+	log.Printf("✅ Bot '%s' verified as @%s (id: %d)", nb.Name, user.Username, user.Id)
+	return nil
+}
+
+// ValidateBots runs getMe for every bot in every scope and returns the failures
+func (bsc *BotScopeConfiguration) ValidateBots(ctx context.Context) []error {
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+
+	for _, namedBots := range bsc.Scopes {
+		for _, namedBot := range namedBots {
+			wg.Add(1)
+			go func(bot NamedBot) {
+				defer wg.Done()
+				botCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+				defer cancel()
+				if err := bot.Validate(botCtx); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}(namedBot)
+		}
+	}
+
+	wg.Wait()
+	return errs
+}
+
+// ShouldValidateBotsOnStartup reads BOT_VALIDATE_ON_STARTUP (default true)
+func ShouldValidateBotsOnStartup() bool {
+	v, err := strconv.ParseBool(os.Getenv("BOT_VALIDATE_ON_STARTUP"))
+	return err != nil || v
+}
+
+// ShouldFailFastOnInvalidBots reads BOT_VALIDATE_FAIL_FAST (default false)
+func ShouldFailFastOnInvalidBots() bool {
+	v, _ := strconv.ParseBool(os.Getenv("BOT_VALIDATE_FAIL_FAST"))
+	return v
+}
+
+// GetScopeBotStatuses returns the identity and state of every bot per scope
+func (bsc *BotScopeConfiguration) GetScopeBotStatuses() map[string][]BotStatus {
+	statuses := make(map[string][]BotStatus)
+	for scope, namedBots := range bsc.Scopes {
+		if len(namedBots) > 0 {
+			scopeStatuses := make([]BotStatus, len(namedBots))
+			for i, namedBot := range namedBots {
+				scopeStatuses[i] = namedBot.Status()
+			}
+			statuses[scope] = scopeStatuses
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBotBusy is returned when a bot has no free slot and its wait queue is full or timed out
+var ErrBotBusy = errors.New("bot is busy")
+
+// BotLimiter caps the concurrent Bot API calls of one token.
+// Callers beyond the cap wait in a bounded queue for up to the queue timeout.
+type BotLimiter struct {
+	slots        chan struct{}
+	maxQueue     int64
+	queueTimeout time.Duration
+	waiting      atomic.Int64
+	rejected     atomic.Int64
+}
+
+// BotLimiterSnapshot is a point-in-time view of a bot's limiter
+type BotLimiterSnapshot struct {
+	Active      int   `json:"active"`
+	MaxInFlight int   `json:"maxInFlight"`
+	Queued      int64 `json:"queued"`
+	MaxQueue    int64 `json:"maxQueue"`
+	Rejected    int64 `json:"rejected"`
+}
+
+func envInt(key string, defaultValue int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+// newBotLimiter reads BOT_MAX_IN_FLIGHT (default 8, 0 = unlimited),
+// BOT_MAX_QUEUE (default 32) and BOT_QUEUE_TIMEOUT (default 2s)
+func newBotLimiter() *BotLimiter {
+	limiter := &BotLimiter{
+		maxQueue:     int64(envInt("BOT_MAX_QUEUE", 32)),
+		queueTimeout: 2 * time.Second,
+	}
+	if maxInFlight := envInt("BOT_MAX_IN_FLIGHT", 8); maxInFlight > 0 {
+		limiter.slots = make(chan struct{}, maxInFlight)
+	}
+	if v := os.Getenv("BOT_QUEUE_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			limiter.queueTimeout = d
+		}
+	}
+	return limiter
+}
+
+// Acquire takes a slot, waiting in the queue if needed, and returns its release function
+func (bl *BotLimiter) Acquire(ctx context.Context) (func(), error) {
+	if bl == nil || bl.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case bl.slots <- struct{}{}:
+		return bl.release, nil
+	default:
+	}
+
+	// صف پر است، بهتره caller سراغ یه بات دیگه بره
+	if bl.waiting.Add(1) > bl.maxQueue {
+		bl.waiting.Add(-1)
+		bl.rejected.Add(1)
+		return nil, ErrBotBusy
+	}
+	defer bl.waiting.Add(-1)
+
+	timer := time.NewTimer(bl.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case bl.slots <- struct{}{}:
+		return bl.release, nil
+	case <-timer.C:
+		bl.rejected.Add(1)
+		return nil, ErrBotBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (bl *BotLimiter) release() {
+	<-bl.slots
+}
+
+// Snapshot returns the current slot usage and queue depth
+func (bl *BotLimiter) Snapshot() BotLimiterSnapshot {
+	if bl == nil {
+		return BotLimiterSnapshot{}
+	}
+	return BotLimiterSnapshot{
+		Active:      len(bl.slots),
+		MaxInFlight: cap(bl.slots),
+		Queued:      bl.waiting.Load(),
+		MaxQueue:    bl.maxQueue,
+		Rejected:    bl.rejected.Load(),
+	}
+}
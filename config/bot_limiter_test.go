@@ -0,0 +1,80 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBotLimiterAcquire(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxInFlight string
+		maxQueue    string
+		// held slots are taken before the tested Acquire
+		held int
+		// queued waiters are parked in the queue before the tested Acquire
+		queued int
+		// releaseAfter frees one held slot while the tested Acquire waits (0 = never)
+		releaseAfter time.Duration
+		cancelAfter  time.Duration
+		wantErr      error
+		wantRejected int64
+	}{
+		{name: "free slot", maxInFlight: "2", maxQueue: "1", held: 1},
+		{name: "unlimited", maxInFlight: "0", maxQueue: "0", held: 5},
+		{name: "no queue rejects at once", maxInFlight: "1", maxQueue: "0", held: 1, wantErr: ErrBotBusy, wantRejected: 1},
+		{name: "queue full rejects at once", maxInFlight: "1", maxQueue: "1", held: 1, queued: 1, wantErr: ErrBotBusy, wantRejected: 1},
+		{name: "queued until a slot is released", maxInFlight: "1", maxQueue: "1", held: 1, releaseAfter: 10 * time.Millisecond},
+		{name: "queue timeout", maxInFlight: "1", maxQueue: "1", held: 1, wantErr: ErrBotBusy, wantRejected: 1},
+		{name: "cancelled while queued", maxInFlight: "1", maxQueue: "1", held: 1, cancelAfter: 10 * time.Millisecond, wantErr: context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BOT_MAX_IN_FLIGHT", tt.maxInFlight)
+			t.Setenv("BOT_MAX_QUEUE", tt.maxQueue)
+			t.Setenv("BOT_QUEUE_TIMEOUT", "100ms")
+			limiter := newBotLimiter()
+
+			var releases []func()
+			for range tt.held {
+				release, err := limiter.Acquire(context.Background())
+				if err != nil {
+					t.Fatalf("taking a held slot: %v", err)
+				}
+				releases = append(releases, release)
+			}
+
+			waiterCtx, stopWaiters := context.WithCancel(context.Background())
+			defer stopWaiters()
+			for range tt.queued {
+				go func() { _, _ = limiter.Acquire(waiterCtx) }()
+			}
+			for limiter.Snapshot().Queued < int64(tt.queued) {
+				time.Sleep(time.Millisecond)
+			}
+
+			if tt.releaseAfter > 0 {
+				time.AfterFunc(tt.releaseAfter, releases[0])
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelAfter > 0 {
+				time.AfterFunc(tt.cancelAfter, cancel)
+			}
+
+			release, err := limiter.Acquire(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Acquire error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				release()
+			}
+			if got := limiter.Snapshot().Rejected; got != tt.wantRejected {
+				t.Errorf("rejected = %d, want %d", got, tt.wantRejected)
+			}
+		})
+	}
+}
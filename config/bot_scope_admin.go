@@ -0,0 +1,199 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+var (
+	ErrScopeNotFound = errors.New("scope not found")
+	ErrScopeExists   = errors.New("scope already exists")
+	ErrBotNotFound   = errors.New("bot not found")
+	ErrBotExists     = errors.New("bot already exists")
+	// ErrInvalidBotScopes wraps validation and getMe failures of an update
+	ErrInvalidBotScopes = errors.New("invalid bot scope configuration")
+)
+
+// AddScope declares a new scope
+func (f *BotScopeFile) AddScope(scope string, entry ScopeFileEntry) error {
+	if _, ok := f.Scopes[scope]; ok {
+		return fmt.Errorf("%w: '%s'", ErrScopeExists, scope)
+	}
+	if entry.Bots == nil {
+		entry.Bots = []BotFileEntry{}
+	}
+	f.Scopes[scope] = entry
+	return nil
+}
+
+// UpdateScope changes the settings of a scope in place
+func (f *BotScopeFile) UpdateScope(scope string, update func(entry *ScopeFileEntry)) error {
+	entry, ok := f.Scopes[scope]
+	if !ok {
+		return fmt.Errorf("%w: '%s'", ErrScopeNotFound, scope)
+	}
+	update(&entry)
+	f.Scopes[scope] = entry
+	return nil
+}
+
+// RemoveScope deletes a scope and its bots
+func (f *BotScopeFile) RemoveScope(scope string) error {
+	if _, ok := f.Scopes[scope]; !ok {
+		return fmt.Errorf("%w: '%s'", ErrScopeNotFound, scope)
+	}
+	delete(f.Scopes, scope)
+	return nil
+}
+
+// RenameScope moves a scope and its bots to a new name
+func (f *BotScopeFile) RenameScope(scope, newName string) error {
+	entry, ok := f.Scopes[scope]
+	if !ok {
+		return fmt.Errorf("%w: '%s'", ErrScopeNotFound, scope)
+	}
+	if _, ok := f.Scopes[newName]; ok {
+		return fmt.Errorf("%w: '%s'", ErrScopeExists, newName)
+	}
+	delete(f.Scopes, scope)
+	f.Scopes[newName] = entry
+	return nil
+}
+
+// AddBot appends a bot to a scope
+func (f *BotScopeFile) AddBot(scope string, bot BotFileEntry) error {
+	entry, ok := f.Scopes[scope]
+	if !ok {
+		return fmt.Errorf("%w: '%s'", ErrScopeNotFound, scope)
+	}
+	if slices.ContainsFunc(entry.Bots, func(b BotFileEntry) bool { return b.Name == bot.Name }) {
+		return fmt.Errorf("%w: '%s' in scope '%s'", ErrBotExists, bot.Name, scope)
+	}
+	entry.Bots = append(entry.Bots, bot)
+	f.Scopes[scope] = entry
+	return nil
+}
+
+// UpdateBot changes a bot of a scope in place
+func (f *BotScopeFile) UpdateBot(scope, name string, update func(bot *BotFileEntry)) error {
+	entry, ok := f.Scopes[scope]
+	if !ok {
+		return fmt.Errorf("%w: '%s'", ErrScopeNotFound, scope)
+	}
+	i := slices.IndexFunc(entry.Bots, func(b BotFileEntry) bool { return b.Name == name })
+	if i < 0 {
+		return fmt.Errorf("%w: '%s' in scope '%s'", ErrBotNotFound, name, scope)
+	}
+	update(&entry.Bots[i])
+	return nil
+}
+
+// RenameBot gives a bot of a scope a new name
+func (f *BotScopeFile) RenameBot(scope, name, newName string) error {
+	entry, ok := f.Scopes[scope]
+	if ok && name != newName && slices.ContainsFunc(entry.Bots, func(b BotFileEntry) bool { return b.Name == newName }) {
+		return fmt.Errorf("%w: '%s' in scope '%s'", ErrBotExists, newName, scope)
+	}
+	return f.UpdateBot(scope, name, func(bot *BotFileEntry) { bot.Name = newName })
+}
+
+// RemoveBot deletes a bot from a scope
+func (f *BotScopeFile) RemoveBot(scope, name string) error {
+	entry, ok := f.Scopes[scope]
+	if !ok {
+		return fmt.Errorf("%w: '%s'", ErrScopeNotFound, scope)
+	}
+	i := slices.IndexFunc(entry.Bots, func(b BotFileEntry) bool { return b.Name == name })
+	if i < 0 {
+		return fmt.Errorf("%w: '%s' in scope '%s'", ErrBotNotFound, name, scope)
+	}
+	entry.Bots = slices.Delete(entry.Bots, i, i+1)
+	f.Scopes[scope] = entry
+	return nil
+}
+
+// writeBotScopeFile replaces path atomically so a reader never sees a half-written file
+func writeBotScopeFile(path string, file *BotScopeFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".bot_scopes-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// فایل توکن‌ها رو داره، فقط خود سرویس بخونه
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// UpdateBotScopes applies change to a copy of the active declaration and swaps the result in.
+// The new declaration is validated, new tokens must pass getMe, and it is written back to
+// BOT_SCOPES_FILE before activation. Without a file the change lives in memory only and
+// persisted is false. On any error the active configuration is left untouched.
+func UpdateBotScopes(ctx context.Context, change func(file *BotScopeFile) error) (bsc *BotScopeConfiguration, persisted bool, err error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	previous := CurrentBotScopes()
+	file := &BotScopeFile{Scopes: map[string]ScopeFileEntry{}}
+	if previous.source != nil {
+		file = previous.source.clone()
+	}
+
+	if err := change(file); err != nil {
+		return nil, false, err
+	}
+	if err := file.Validate(); err != nil {
+		return nil, false, fmt.Errorf("%w: %w", ErrInvalidBotScopes, err)
+	}
+
+	bsc = newBotScopeConfigurationFromFile(file, previous)
+
+	validateCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	for _, namedBots := range bsc.Scopes {
+		for _, namedBot := range namedBots {
+			if !previous.isNewBot(namedBot) {
+				continue
+			}
+			// توکن جدید قبل از فعال شدن باید getMe رو رد کنه
+			if err := namedBot.Validate(validateCtx); err != nil {
+				return nil, false, fmt.Errorf("%w: %w", ErrInvalidBotScopes, err)
+			}
+		}
+	}
+
+	if path := BotScopesFile(); path != "" {
+		if err := writeBotScopeFile(path, file); err != nil {
+			return nil, false, fmt.Errorf("persist %s: %w", path, err)
+		}
+		persisted = true
+	} else {
+		log.Printf("⚠️ BOT_SCOPES_FILE not set, bot scope change is not persisted")
+	}
+
+	activateBotScopes(bsc)
+	log.Printf("🛠️ Bot scopes updated: %v", bsc.GetAllScopes())
+	return bsc, persisted, nil
+}
@@ -0,0 +1,421 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-uploader/pkg/telegram_api"
+	"go-uploader/utils"
+	"log"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+)
+
+// BotScopeFile is the declarative scope configuration read from BOT_SCOPES_FILE
+type BotScopeFile struct {
+	// Buckets are accepted in addition to the scope names, e.g. for /direct uploads
+	Buckets []string                  `json:"buckets,omitempty"`
+	Racing  RacingSettings            `json:"racing"`
+	Scopes  map[string]ScopeFileEntry `json:"scopes"`
+}
+
+// ScopeFileEntry declares the bots and settings of one scope
+type ScopeFileEntry struct {
+	DestChatId string `json:"destChatId,omitempty"`
+	// BackupChatIds receive a copy of every upload of the scope
+	BackupChatIds []string       `json:"backupChatIds,omitempty"`
+	Strategy      string         `json:"strategy,omitempty"`
+	UploadRouting string         `json:"uploadRouting,omitempty"`
+	Bots          []BotFileEntry `json:"bots"`
+}
+
+// BotFileEntry is one bot token of a scope
+type BotFileEntry struct {
+	Name     string `json:"name"`
+	Token    string `json:"token"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// RacingSettings are the defaults for every scope; empty fields fall back to env vars
+type RacingSettings struct {
+	Strategy      string `json:"strategy,omitempty"`
+	MaxRacingBots int    `json:"maxRacingBots,omitempty"`
+	HedgeDelay    string `json:"hedgeDelay,omitempty"`
+	UploadRouting string `json:"uploadRouting,omitempty"`
+}
+
+// ScopeSettings are the per-scope values of the configuration file
+type ScopeSettings struct {
+	DestChatId    string
+	BackupChatIds []string
+	Strategy      string
+	UploadRouting string
+}
+
+var (
+	botNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+	tokenPattern   = regexp.MustCompile(`^\d+:[A-Za-z0-9_-]{20,}$`)
+	chatIdPattern  = regexp.MustCompile(`^(-?\d+|@[A-Za-z0-9_]{5,})$`)
+)
+
+// Validate checks the whole file and returns every problem found
+func (f *BotScopeFile) Validate() error {
+	var errs []error
+
+	if len(f.Scopes) == 0 {
+		errs = append(errs, errors.New("no scopes defined"))
+	}
+	for _, bucket := range f.Buckets {
+		if err := s3utils.CheckValidBucketNameStrict(bucket); err != nil {
+			errs = append(errs, fmt.Errorf("bucket '%s': %v", bucket, err))
+		}
+	}
+	errs = append(errs, f.Racing.validate("racing")...)
+
+	for scope, entry := range f.Scopes {
+		// اسم scope همون اسم باکت MinIO هست
+		if err := s3utils.CheckValidBucketNameStrict(scope); err != nil {
+			errs = append(errs, fmt.Errorf("scope '%s': not a valid bucket name: %v", scope, err))
+		}
+		if entry.DestChatId != "" && !chatIdPattern.MatchString(entry.DestChatId) {
+			errs = append(errs, fmt.Errorf("scope '%s': invalid destChatId '%s'", scope, entry.DestChatId))
+		}
+		for _, chatId := range entry.BackupChatIds {
+			switch {
+			case !chatIdPattern.MatchString(chatId):
+				errs = append(errs, fmt.Errorf("scope '%s': invalid backup chat '%s'", scope, chatId))
+			case chatId == entry.DestChatId:
+				errs = append(errs, fmt.Errorf("scope '%s': backup chat '%s' is the destination chat", scope, chatId))
+			}
+		}
+		errs = append(errs, RacingSettings{Strategy: entry.Strategy, UploadRouting: entry.UploadRouting}.validate("scope '"+scope+"'")...)
+
+		names := map[string]bool{}
+		tokens := map[string]bool{}
+		for i, bot := range entry.Bots {
+			switch {
+			case !botNamePattern.MatchString(bot.Name):
+				errs = append(errs, fmt.Errorf("scope '%s': bot #%d has an invalid name '%s'", scope, i+1, bot.Name))
+			case names[bot.Name]:
+				errs = append(errs, fmt.Errorf("scope '%s': duplicate bot name '%s'", scope, bot.Name))
+			}
+			names[bot.Name] = true
+
+			switch {
+			case !tokenPattern.MatchString(bot.Token):
+				errs = append(errs, fmt.Errorf("scope '%s': bot '%s' has a malformed token", scope, bot.Name))
+			case tokens[bot.Token]:
+				errs = append(errs, fmt.Errorf("scope '%s': bot '%s' repeats the token of another bot", scope, bot.Name))
+			}
+			tokens[bot.Token] = true
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (rs RacingSettings) validate(where string) []error {
+	var errs []error
+	switch rs.Strategy {
+	case "", "race", "hedge", "single":
+	default:
+		errs = append(errs, fmt.Errorf("%s: unknown strategy '%s'", where, rs.Strategy))
+	}
+	switch rs.UploadRouting {
+	case "", "round-robin", "least-in-flight":
+	default:
+		errs = append(errs, fmt.Errorf("%s: unknown uploadRouting '%s'", where, rs.UploadRouting))
+	}
+	if rs.MaxRacingBots < 0 {
+		errs = append(errs, fmt.Errorf("%s: maxRacingBots must not be negative", where))
+	}
+	if rs.HedgeDelay != "" {
+		if d, err := time.ParseDuration(rs.HedgeDelay); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid hedgeDelay '%s'", where, rs.HedgeDelay))
+		}
+	}
+	return errs
+}
+
+// ReadBotScopeFile reads and validates a scope configuration file
+func ReadBotScopeFile(path string) (*BotScopeFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var file BotScopeFile
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := file.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return &file, nil
+}
+
+// clone returns a deep copy that can be changed without affecting f
+func (f *BotScopeFile) clone() *BotScopeFile {
+	clone := &BotScopeFile{
+		Buckets: slices.Clone(f.Buckets),
+		Racing:  f.Racing,
+		Scopes:  make(map[string]ScopeFileEntry, len(f.Scopes)),
+	}
+	for scope, entry := range f.Scopes {
+		entry.Bots = slices.Clone(entry.Bots)
+		entry.BackupChatIds = slices.Clone(entry.BackupChatIds)
+		clone.Scopes[scope] = entry
+	}
+	return clone
+}
+
+// newBotScopeConfigurationFromFile builds the scopes of a file. Bots whose token was
+// already configured keep the identity, statistics, breaker and limiter of previous,
+// even when the bot or its scope was renamed.
+func newBotScopeConfigurationFromFile(file *BotScopeFile, previous *BotScopeConfiguration) *BotScopeConfiguration {
+	bsc := &BotScopeConfiguration{
+		Scopes:   make(map[string][]NamedBot, len(file.Scopes)),
+		Settings: make(map[string]ScopeSettings, len(file.Scopes)),
+		Racing:   file.Racing,
+		Buckets:  file.Buckets,
+		source:   file,
+	}
+
+	for scope, entry := range file.Scopes {
+		namedBots := make([]NamedBot, 0, len(entry.Bots))
+		for _, bot := range entry.Bots {
+			namedBot, ok := previous.findBotByToken(scope, bot.Token)
+			if !ok {
+				namedBot = newNamedBot(bot.Name, telegram_api.New(bot.Token))
+			}
+			namedBot.Name = bot.Name
+			namedBot.Disabled = bot.Disabled
+			namedBots = append(namedBots, namedBot)
+		}
+
+		bsc.Scopes[scope] = namedBots
+		bsc.Settings[scope] = ScopeSettings{
+			DestChatId:    entry.DestChatId,
+			BackupChatIds: entry.BackupChatIds,
+			Strategy:      entry.Strategy,
+			UploadRouting: entry.UploadRouting,
+		}
+	}
+
+	return bsc
+}
+
+// findBotByToken returns the bot using token, preferring the given scope
+func (bsc *BotScopeConfiguration) findBotByToken(scope, token string) (NamedBot, bool) {
+	if bsc == nil {
+		return NamedBot{}, false
+	}
+
+	scopes := []string{scope}
+	for _, other := range bsc.GetAllScopes() {
+		if other != scope {
+			scopes = append(scopes, other)
+		}
+	}
+	slices.Sort(scopes[1:])
+
+	for _, s := range scopes {
+		for _, namedBot := range bsc.Scopes[s] {
+			if namedBot.API.HasToken(token) {
+				return namedBot, true
+			}
+		}
+	}
+	return NamedBot{}, false
+}
+
+// isNewBot reports whether namedBot has no runtime state in bsc yet
+func (bsc *BotScopeConfiguration) isNewBot(namedBot NamedBot) bool {
+	for _, namedBots := range bsc.Scopes {
+		for _, existing := range namedBots {
+			if existing.Stats == namedBot.Stats {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ValidBuckets returns every bucket name accepted by the storage endpoints
+func (bsc *BotScopeConfiguration) ValidBuckets() []string {
+	buckets := make([]string, 0, len(bsc.Scopes)+len(bsc.Buckets))
+	for scope := range bsc.Scopes {
+		buckets = append(buckets, scope)
+	}
+	for _, bucket := range bsc.Buckets {
+		if !slices.Contains(buckets, bucket) {
+			buckets = append(buckets, bucket)
+		}
+	}
+	slices.Sort(buckets)
+	return buckets
+}
+
+var (
+	currentBotScopes atomic.Pointer[BotScopeConfiguration]
+	reloadMu         sync.Mutex
+)
+
+// CurrentBotScopes returns the active scope configuration. Requests should keep
+// the returned value for their whole lifetime; a reload swaps in a new one.
+func CurrentBotScopes() *BotScopeConfiguration {
+	if bsc := currentBotScopes.Load(); bsc != nil {
+		return bsc
+	}
+	return &BotScopeConfiguration{Scopes: map[string][]NamedBot{}}
+}
+
+func activateBotScopes(bsc *BotScopeConfiguration) {
+	currentBotScopes.Store(bsc)
+	utils.SetValidBuckets(bsc.ValidBuckets())
+}
+
+// BotScopesFile returns BOT_SCOPES_FILE; empty means the BOT_* env vars are used
+func BotScopesFile() string {
+	return os.Getenv("BOT_SCOPES_FILE")
+}
+
+// LoadBotScopes loads the scopes from BOT_SCOPES_FILE, or from the BOT_* env vars
+// when no file is configured, and makes them the active configuration
+func LoadBotScopes() (*BotScopeConfiguration, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	var bsc *BotScopeConfiguration
+	if path := BotScopesFile(); path != "" {
+		file, err := ReadBotScopeFile(path)
+		if err != nil {
+			return nil, err
+		}
+		bsc = newBotScopeConfigurationFromFile(file, nil)
+		log.Printf("✅ Bot scopes loaded from %s", path)
+	} else {
+		bsc = NewBotScopeConfiguration()
+	}
+
+	activateBotScopes(bsc)
+	return bsc, nil
+}
+
+// ReloadBotScopes re-reads BOT_SCOPES_FILE and swaps it in. An invalid file leaves
+// the active configuration untouched. New tokens are checked with getMe first
+// when BOT_VALIDATE_ON_STARTUP is on.
+func ReloadBotScopes(ctx context.Context) (*BotScopeConfiguration, error) {
+	path := BotScopesFile()
+	if path == "" {
+		return nil, errors.New("BOT_SCOPES_FILE is not set")
+	}
+
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	file, err := ReadBotScopeFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := CurrentBotScopes()
+	bsc := newBotScopeConfigurationFromFile(file, previous)
+
+	if ShouldValidateBotsOnStartup() {
+		validateCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		for _, namedBots := range bsc.Scopes {
+			for _, namedBot := range namedBots {
+				if previous.isNewBot(namedBot) {
+					_ = namedBot.Validate(validateCtx)
+				}
+			}
+		}
+		cancel()
+	}
+
+	activateBotScopes(bsc)
+	log.Printf("🔄 Bot scopes reloaded from %s: %v", path, bsc.GetAllScopes())
+	return bsc, nil
+}
+
+// getBotScopesWatchInterval reads BOT_SCOPES_WATCH_INTERVAL (default 10s, 0 = SIGHUP only)
+func getBotScopesWatchInterval() time.Duration {
+	if v := os.Getenv("BOT_SCOPES_WATCH_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return 10 * time.Second
+}
+
+// WatchBotScopes reloads BOT_SCOPES_FILE on SIGHUP and whenever its modification
+// time changes, until ctx is cancelled
+func WatchBotScopes(ctx context.Context) {
+	path := BotScopesFile()
+	if path == "" {
+		return
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var poll <-chan time.Time
+	if interval := getBotScopesWatchInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	modTime := func() time.Time {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+	lastModTime := modTime()
+
+	reload := func(reason string) {
+		log.Printf("🔄 Reloading bot scopes (%s)", reason)
+		if _, err := ReloadBotScopes(ctx); err != nil {
+			log.Printf("❌ Bot scope reload failed, keeping current configuration: %v", err)
+		}
+	}
+
+	log.Printf("👀 Watching %s for changes", path)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			lastModTime = modTime()
+			reload("SIGHUP")
+		case <-poll:
+			// فایل نیمه‌نوشته رد میشه؛ نوشتن نهایی دوباره mtime رو عوض می‌کنه
+			if current := modTime(); !current.IsZero() && !current.Equal(lastModTime) {
+				lastModTime = current
+				reload("file changed")
+			}
+		}
+	}
+}
@@ -9,8 +9,26 @@ import (
 
 // NamedBot represents a bot with its name and API instance
 type NamedBot struct {
-	Name string
-	API  *telegram_api.TelegramAPI
+	Name     string
+	API      *telegram_api.TelegramAPI
+	Identity *BotIdentity
+	Stats    *BotStats
+	Breaker  *CircuitBreaker
+	Limiter  *BotLimiter
+	// Disabled is set by the configuration (file or admin API), unlike Identity's validation result
+	Disabled bool
+}
+
+// newNamedBot creates a named bot with fresh runtime state
+func newNamedBot(name string, api *telegram_api.TelegramAPI) NamedBot {
+	return NamedBot{
+		Name:     name,
+		API:      api,
+		Identity: &BotIdentity{},
+		Stats:    &BotStats{},
+		Breaker:  &CircuitBreaker{},
+		Limiter:  newBotLimiter(),
+	}
 }
 
 // BotScope represents a collection of named bots for a specific scope
@@ -19,43 +37,44 @@ type BotScope struct {
 	NamedBots []NamedBot
 }
 
-// BotScopeConfiguration manages all bot scopes
+// BotScopeConfiguration manages all bot scopes.
+// A loaded configuration is never modified; reloads build a new one.
 type BotScopeConfiguration struct {
 	Scopes map[string][]NamedBot
+	// Settings, Racing and Buckets are only set when loaded from BOT_SCOPES_FILE
+	Settings map[string]ScopeSettings
+	Racing   RacingSettings
+	Buckets  []string
+	// source is the declaration this configuration was built from, used by admin updates
+	source *BotScopeFile
 }
 
-// NewBotScopeConfiguration creates and configures bot scopes
+// NewBotScopeConfiguration creates and configures bot scopes from the BOT_* env vars
 func NewBotScopeConfiguration() *BotScopeConfiguration {
 	// Parse bot tokens from environment variables (supports comma-separated values)
-	telegramBots := parseNamedBotsFromEnv("BOT_TELEGRAM", "telegram")
-	instagramBots := parseNamedBotsFromEnv("BOT_INSTAGRAM", "instagram")
-	trackerBots := parseNamedBotsFromEnv("BOT_TRACKER", "tracker")
-	influencerBots := parseNamedBotsFromEnv("BOT_INFLUENCER", "influencer")
-
-	// Configure scopes with named bots
-	scopes := map[string][]NamedBot{
-		"telegram":   telegramBots,
-		"instagram":  instagramBots,
-		"tracker":    trackerBots,
-		"influencer": influencerBots,
+	file := &BotScopeFile{
+		Scopes: map[string]ScopeFileEntry{
+			"telegram":   {Bots: parseBotEntriesFromEnv("BOT_TELEGRAM", "telegram")},
+			"instagram":  {Bots: parseBotEntriesFromEnv("BOT_INSTAGRAM", "instagram")},
+			"tracker":    {Bots: parseBotEntriesFromEnv("BOT_TRACKER", "tracker")},
+			"influencer": {Bots: parseBotEntriesFromEnv("BOT_INFLUENCER", "influencer")},
+		},
 	}
 
-	return &BotScopeConfiguration{
-		Scopes: scopes,
-	}
+	return newBotScopeConfigurationFromFile(file, nil)
 }
 
-// parseNamedBotsFromEnv parses comma-separated bot tokens with names from environment variable
+// parseBotEntriesFromEnv parses comma-separated bot tokens with names from environment variable
 // Format: name1<token1>,name2<token2>,name3<token3>
 // If no name is provided (just token), defaults to "relic" for first bot and scope_N for others
-func parseNamedBotsFromEnv(envKey, scopeName string) []NamedBot {
+func parseBotEntriesFromEnv(envKey, scopeName string) []BotFileEntry {
 	envValue := os.Getenv(envKey)
 	if envValue == "" {
-		return []NamedBot{}
+		return []BotFileEntry{}
 	}
 
 	tokens := strings.Split(envValue, ",")
-	var namedBots []NamedBot
+	var entries []BotFileEntry
 
 	for i, entry := range tokens {
 		entry = strings.TrimSpace(entry)
@@ -86,36 +105,39 @@ func parseNamedBotsFromEnv(envKey, scopeName string) []NamedBot {
 			}
 
 			if botToken != "" && botName != "" {
-				bot := telegram_api.New(botToken)
-				namedBots = append(namedBots, NamedBot{
-					Name: botName,
-					API:  bot,
-				})
+				entries = append(entries, BotFileEntry{Name: botName, Token: botToken})
 			}
 		}
 	}
 
-	return namedBots
+	return entries
 }
 
 // GetBots returns the bot APIs for a given scope (for backward compatibility)
 func (bsc *BotScopeConfiguration) GetBots(scope string) []*telegram_api.TelegramAPI {
-	if namedBots, exists := bsc.Scopes[scope]; exists && len(namedBots) > 0 {
-		bots := make([]*telegram_api.TelegramAPI, len(namedBots))
-		for i, namedBot := range namedBots {
-			bots[i] = namedBot.API
-		}
-		return bots
+	namedBots := bsc.GetNamedBots(scope)
+	bots := make([]*telegram_api.TelegramAPI, len(namedBots))
+	for i, namedBot := range namedBots {
+		bots[i] = namedBot.API
 	}
-	return []*telegram_api.TelegramAPI{}
+	return bots
 }
 
-// GetNamedBots returns the named bots for a given scope
+// HasScope reports whether a scope is configured, even if it has no bots
+func (bsc *BotScopeConfiguration) HasScope(scope string) bool {
+	_, ok := bsc.Scopes[scope]
+	return ok
+}
+
+// GetNamedBots returns the enabled named bots for a given scope
 func (bsc *BotScopeConfiguration) GetNamedBots(scope string) []NamedBot {
-	if namedBots, exists := bsc.Scopes[scope]; exists {
-		return namedBots
+	enabled := []NamedBot{}
+	for _, namedBot := range bsc.Scopes[scope] {
+		if namedBot.Enabled() {
+			enabled = append(enabled, namedBot)
+		}
 	}
-	return []NamedBot{}
+	return enabled
 }
 
 // GetScope returns the bot array for a specific scope (for backward compatibility)
@@ -123,57 +145,43 @@ func (bsc *BotScopeConfiguration) GetScope(scopeName string) []*telegram_api.Tel
 	return bsc.GetBots(scopeName)
 }
 
-// AddBotToScope adds a named bot to a specific scope
-func (bsc *BotScopeConfiguration) AddBotToScope(scopeName string, botName string, bot *telegram_api.TelegramAPI) {
-	if bsc.Scopes[scopeName] == nil {
-		bsc.Scopes[scopeName] = []NamedBot{}
-	}
-	namedBot := NamedBot{Name: botName, API: bot}
-	bsc.Scopes[scopeName] = append(bsc.Scopes[scopeName], namedBot)
+// GetScopeSettings returns the file settings of a scope (empty for env configuration)
+func (bsc *BotScopeConfiguration) GetScopeSettings(scope string) ScopeSettings {
+	return bsc.Settings[scope]
 }
 
-// CreateCustomScope creates a new scope with specified tokens and names
-// Supports both name<token> format and plain tokens
-func (bsc *BotScopeConfiguration) CreateCustomScope(scopeName string, tokens []string) {
-	var namedBots []NamedBot
-	for i, entry := range tokens {
-		entry = strings.TrimSpace(entry)
-		if entry != "" {
-			var botName, botToken string
+// GetDestChatId returns the destination chat of a scope from the config file,
+// DEST_CHAT_ID_<SCOPE> or DEST_CHAT_ID; empty when none is configured
+func (bsc *BotScopeConfiguration) GetDestChatId(scope string) string {
+	if chatId := bsc.Settings[scope].DestChatId; chatId != "" {
+		return chatId
+	}
+	if chatId := os.Getenv("DEST_CHAT_ID_" + strings.ToUpper(scope)); chatId != "" {
+		return chatId
+	}
+	return os.Getenv("DEST_CHAT_ID")
+}
 
-			// Check if entry contains name<token> format
-			if strings.Contains(entry, "<") && strings.Contains(entry, ">") {
-				// Parse name<token> format
-				startIdx := strings.Index(entry, "<")
-				endIdx := strings.LastIndex(entry, ">")
+// GetBackupChatIds returns the chats that receive a copy of every upload of a scope,
+// from the config file, BACKUP_CHAT_IDS_<SCOPE> or BACKUP_CHAT_IDS (comma-separated)
+func (bsc *BotScopeConfiguration) GetBackupChatIds(scope string) []string {
+	if chatIds := bsc.Settings[scope].BackupChatIds; len(chatIds) > 0 {
+		return chatIds
+	}
 
-				if startIdx > 0 && endIdx > startIdx {
-					botName = strings.TrimSpace(entry[:startIdx])
-					botToken = strings.TrimSpace(entry[startIdx+1 : endIdx])
-				} else {
-					// Invalid format, skip this entry
-					continue
-				}
-			} else {
-				// No name provided, use default naming
-				botToken = entry
-				if i == 0 {
-					botName = "relic" // First bot is always named "relic"
-				} else {
-					botName = fmt.Sprintf("%s_%d", scopeName, i+1)
-				}
-			}
+	envValue := os.Getenv("BACKUP_CHAT_IDS_" + strings.ToUpper(scope))
+	if envValue == "" {
+		envValue = os.Getenv("BACKUP_CHAT_IDS")
+	}
 
-			if botToken != "" && botName != "" {
-				bot := telegram_api.New(botToken)
-				namedBots = append(namedBots, NamedBot{
-					Name: botName,
-					API:  bot,
-				})
-			}
+	destChatId := bsc.GetDestChatId(scope)
+	chatIds := []string{}
+	for _, chatId := range strings.Split(envValue, ",") {
+		if chatId = strings.TrimSpace(chatId); chatId != "" && chatId != destChatId {
+			chatIds = append(chatIds, chatId)
 		}
 	}
-	bsc.Scopes[scopeName] = namedBots
+	return chatIds
 }
 
 // GetAllScopes returns all available scope names
@@ -0,0 +1,235 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"go-uploader/pkg/telegram_api"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// statsAlpha is the EWMA weight of the newest sample
+	statsAlpha = 0.2
+	// statsLatencyWindow is how many recent latencies are kept for percentiles
+	statsLatencyWindow = 64
+	// rateLimitCooldown is how long a 429 without retry_after penalises a bot
+	rateLimitCooldown = 30 * time.Second
+)
+
+// BotStats keeps rolling latency and success statistics for a bot
+type BotStats struct {
+	mu             sync.Mutex
+	ewmaLatency    float64 // milliseconds
+	errorRate      float64 // EWMA of failures, 0..1
+	requests       int64
+	failures       int64
+	inFlight       int64
+	last429        time.Time
+	rateLimitUntil time.Time
+	latencies      [statsLatencyWindow]float64
+	latencyCount   int
+	latencyNext    int
+}
+
+// BotStatsSnapshot is a point-in-time view of a bot's statistics
+type BotStatsSnapshot struct {
+	Name           string             `json:"name"`
+	Requests       int64              `json:"requests"`
+	Failures       int64              `json:"failures"`
+	InFlight       int64              `json:"inFlight"`
+	EwmaLatencyMs  float64            `json:"ewmaLatencyMs"`
+	P90LatencyMs   float64            `json:"p90LatencyMs"`
+	ErrorRate      float64            `json:"errorRate"`
+	Last429        *time.Time         `json:"last429,omitempty"`
+	RateLimitUntil *time.Time         `json:"rateLimitUntil,omitempty"`
+	Score          float64            `json:"score"`
+	Limiter        BotLimiterSnapshot `json:"limiter"`
+}
+
+// Begin marks a request as started and returns the function that records its outcome.
+// Cancelled requests (lost races) are not counted against the bot.
+func (bs *BotStats) Begin() func(err error) {
+	if bs == nil {
+		return func(error) {}
+	}
+
+	bs.mu.Lock()
+	bs.inFlight++
+	bs.mu.Unlock()
+
+	started := time.Now()
+	return func(err error) {
+		bs.mu.Lock()
+		defer bs.mu.Unlock()
+		bs.inFlight--
+
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		bs.record(time.Since(started), err)
+	}
+}
+
+func (bs *BotStats) record(latency time.Duration, err error) {
+	bs.requests++
+
+	failed := 0.0
+	if err != nil {
+		bs.failures++
+		failed = 1
+
+		var apiErr *telegram_api.APIError
+		if errors.As(err, &apiErr) && apiErr.IsRateLimited() {
+			bs.last429 = time.Now()
+			cooldown := rateLimitCooldown
+			if apiErr.RetryAfter > 0 {
+				cooldown = time.Duration(apiErr.RetryAfter) * time.Second
+			}
+			bs.rateLimitUntil = bs.last429.Add(cooldown)
+		}
+	} else {
+		ms := float64(latency) / float64(time.Millisecond)
+		if bs.ewmaLatency == 0 {
+			bs.ewmaLatency = ms
+		} else {
+			bs.ewmaLatency = statsAlpha*ms + (1-statsAlpha)*bs.ewmaLatency
+		}
+
+		bs.latencies[bs.latencyNext] = ms
+		bs.latencyNext = (bs.latencyNext + 1) % statsLatencyWindow
+		if bs.latencyCount < statsLatencyWindow {
+			bs.latencyCount++
+		}
+	}
+
+	if bs.requests == 1 {
+		bs.errorRate = failed
+	} else {
+		bs.errorRate = statsAlpha*failed + (1-statsAlpha)*bs.errorRate
+	}
+}
+
+// score is lower for better bots; unmeasured bots score 0 so they get tried
+func (bs *BotStats) score(now time.Time) float64 {
+	if bs.requests == 0 {
+		return 0
+	}
+
+	latency := bs.ewmaLatency
+	if latency == 0 {
+		// هیچ درخواست موفقی نداشته
+		latency = 10000
+	}
+
+	score := latency * (1 + 4*bs.errorRate)
+	score += float64(bs.inFlight) * latency / 2
+	if now.Before(bs.rateLimitUntil) {
+		score += 1e6
+	}
+	return score
+}
+
+func (bs *BotStats) percentile(p float64) float64 {
+	if bs.latencyCount == 0 {
+		return 0
+	}
+	samples := make([]float64, bs.latencyCount)
+	copy(samples, bs.latencies[:bs.latencyCount])
+	sort.Float64s(samples)
+	return samples[int(p*float64(len(samples)-1))]
+}
+
+// Score returns the selection score of the bot; lower is better
+func (nb NamedBot) Score() float64 {
+	if nb.Stats == nil {
+		return 0
+	}
+	nb.Stats.mu.Lock()
+	defer nb.Stats.mu.Unlock()
+	return nb.Stats.score(time.Now())
+}
+
+// InFlight returns the number of requests currently running on the bot
+func (nb NamedBot) InFlight() int64 {
+	if nb.Stats == nil {
+		return 0
+	}
+	nb.Stats.mu.Lock()
+	defer nb.Stats.mu.Unlock()
+	return nb.Stats.inFlight
+}
+
+// P90Latency returns the 90th percentile of recent successful request latencies
+func (nb NamedBot) P90Latency() time.Duration {
+	if nb.Stats == nil {
+		return 0
+	}
+	nb.Stats.mu.Lock()
+	defer nb.Stats.mu.Unlock()
+	return time.Duration(nb.Stats.percentile(0.9) * float64(time.Millisecond))
+}
+
+// StatsSnapshot returns the current statistics of the bot
+func (nb NamedBot) StatsSnapshot() BotStatsSnapshot {
+	snapshot := BotStatsSnapshot{Name: nb.Name, Limiter: nb.Limiter.Snapshot()}
+	if nb.Stats == nil {
+		return snapshot
+	}
+
+	nb.Stats.mu.Lock()
+	defer nb.Stats.mu.Unlock()
+	snapshot.Requests = nb.Stats.requests
+	snapshot.Failures = nb.Stats.failures
+	snapshot.InFlight = nb.Stats.inFlight
+	snapshot.EwmaLatencyMs = nb.Stats.ewmaLatency
+	snapshot.P90LatencyMs = nb.Stats.percentile(0.9)
+	snapshot.ErrorRate = nb.Stats.errorRate
+	snapshot.Score = nb.Stats.score(time.Now())
+	if !nb.Stats.last429.IsZero() {
+		last429 := nb.Stats.last429
+		snapshot.Last429 = &last429
+	}
+	if time.Now().Before(nb.Stats.rateLimitUntil) {
+		rateLimitUntil := nb.Stats.rateLimitUntil
+		snapshot.RateLimitUntil = &rateLimitUntil
+	}
+	return snapshot
+}
+
+// RankNamedBots returns the bots ordered by score, best first; ties keep config order
+func RankNamedBots(namedBots []NamedBot) []NamedBot {
+	now := time.Now()
+	scores := make(map[string]float64, len(namedBots))
+	for _, namedBot := range namedBots {
+		if namedBot.Stats == nil {
+			continue
+		}
+		namedBot.Stats.mu.Lock()
+		scores[namedBot.Name] = namedBot.Stats.score(now)
+		namedBot.Stats.mu.Unlock()
+	}
+
+	ranked := make([]NamedBot, len(namedBots))
+	copy(ranked, namedBots)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return scores[ranked[i].Name] < scores[ranked[j].Name]
+	})
+	return ranked
+}
+
+// GetScopeBotStats returns the statistics of every bot per scope
+func (bsc *BotScopeConfiguration) GetScopeBotStats() map[string][]BotStatsSnapshot {
+	stats := make(map[string][]BotStatsSnapshot)
+	for scope, namedBots := range bsc.Scopes {
+		if len(namedBots) > 0 {
+			scopeStats := make([]BotStatsSnapshot, len(namedBots))
+			for i, namedBot := range namedBots {
+				scopeStats[i] = namedBot.StatsSnapshot()
+			}
+			stats[scope] = scopeStats
+		}
+	}
+	return stats
+}
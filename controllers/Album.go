@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-uploader/config"
+	"go-uploader/models"
+	"go-uploader/pkg/record_store"
+	"go-uploader/pkg/telegram_api"
+	"go-uploader/utils"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	minAlbumItems = 2
+	maxAlbumItems = 10
+)
+
+// AlbumLinkRequest is the JSON body accepted by UploadAlbumToTelegram
+type AlbumLinkRequest struct {
+	Links   []string `json:"links"`
+	BotName string   `json:"botName,omitempty"`
+}
+
+// fetchExternalLink downloads a validated external URL of at most maxDownloadSize bytes
+// and returns its data and file name
+func fetchExternalLink(ctx context.Context, link string) ([]byte, string, error) {
+	requestURI, err := url.ParseRequestURI(link)
+	if err != nil {
+		return nil, "", fmt.Errorf("link is invalid: %s", link)
+	}
+
+	if err := validateExternalURL(link); err != nil {
+		return nil, "", err
+	}
+
+	reqCtx, cancelReq := context.WithTimeout(ctx, 60*time.Second)
+	defer cancelReq()
+
+	req, err := http.NewRequestWithContext(reqCtx, "GET", requestURI.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// ریدایرکت‌ها هم مثل خود لینک چک میشن تا به شبکه داخلی نرسن
+	res, err := externalLinkClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+
+	if res.StatusCode != 200 {
+		return nil, "", fmt.Errorf("link returned status %d: %s", res.StatusCode, link)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxDownloadSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxDownloadSize {
+		return nil, "", fmt.Errorf("link is larger than %d bytes: %s", maxDownloadSize, link)
+	}
+
+	splitUrl := strings.Split(requestURI.Path, "/")
+	fileName := splitUrl[len(splitUrl)-1]
+	if fileName == "" {
+		fileName = "file"
+	}
+
+	return data, fileName, nil
+}
+
+// UploadAlbumToTelegram uploads 2-10 files or links as a single Telegram album.
+// The uploaded files come first and the links after them, each in the order they were sent.
+func UploadAlbumToTelegram(ctx *fiber.Ctx) error {
+	botName := ctx.Params("botName", "")
+	if !utils.IsValidBucket(botName) {
+		return ctx.Status(400).JSON(models.GenericResponse{
+			Result:  false,
+			Message: "bot name is not valid",
+		})
+	}
+
+	preferredBotName := ctx.Params("specificBot", "")
+
+	var items []telegram_api.MediaGroupItem
+	var links []string
+
+	if strings.HasPrefix(ctx.Get("Content-Type"), "application/json") {
+		var body AlbumLinkRequest
+		if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+			return ctx.Status(400).JSON(models.GenericResponse{
+				Result:  false,
+				Message: err.Error(),
+			})
+		}
+		links = body.Links
+		if preferredBotName == "" {
+			preferredBotName = body.BotName
+		}
+	} else {
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			return ctx.Status(400).JSON(models.GenericResponse{
+				Result:  false,
+				Message: err.Error(),
+			})
+		}
+
+		if len(form.File["file"])+len(form.Value["link"]) > maxAlbumItems {
+			return ctx.Status(400).JSON(models.GenericResponse{
+				Result:  false,
+				Message: fmt.Sprintf("album needs %d-%d items", minAlbumItems, maxAlbumItems),
+			})
+		}
+
+		for _, file := range form.File["file"] {
+			buf, err := utils.OpenFile(file)
+			if err != nil {
+				return ctx.Status(400).JSON(models.GenericResponse{
+					Result:  false,
+					Message: err.Error(),
+				})
+			}
+			items = append(items, telegram_api.MediaGroupItem{
+				ContentType: http.DetectContentType(buf.Bytes()),
+				FileName:    file.Filename,
+				Data:        buf.Bytes(),
+			})
+		}
+
+		links = form.Value["link"]
+		if preferredBotName == "" && len(form.Value["botName"]) > 0 {
+			preferredBotName = form.Value["botName"][0]
+		}
+	}
+
+	total := len(items) + len(links)
+	if total < minAlbumItems || total > maxAlbumItems {
+		return ctx.Status(400).JSON(models.GenericResponse{
+			Result:  false,
+			Message: fmt.Sprintf("album needs %d-%d items, got %d", minAlbumItems, maxAlbumItems, total),
+		})
+	}
+
+	for _, link := range links {
+		data, fileName, err := fetchExternalLink(ctx.UserContext(), link)
+		if err != nil {
+			return ctx.Status(400).JSON(models.GenericResponse{
+				Result:  false,
+				Message: err.Error(),
+			})
+		}
+		items = append(items, telegram_api.MediaGroupItem{
+			ContentType: http.DetectContentType(data),
+			FileName:    fileName,
+			Data:        data,
+		})
+	}
+
+	botScopeConfig, err := getLocal[*config.BotScopeConfiguration](ctx, "BOT_SCOPE_CONFIG")
+	if err != nil {
+		return err
+	}
+	namedBots := botScopeConfig.GetNamedBots(botName)
+	logNamedBots(namedBots, botName)
+
+	if preferredBotName != "" {
+		log.Printf("🎯 Requested specific bot for album: '%s'", preferredBotName)
+	}
+
+	destChatId := botScopeConfig.GetDestChatId(botName)
+	if destChatId == "" {
+		return noDestChatResponse(ctx, botName)
+	}
+
+	results, usedBotName, triedBots, err := uploadMediaGroupRouted(ctx.UserContext(), botName, namedBots, preferredBotName, items, destChatId)
+	if err != nil {
+		log.Printf("Error Occurred -> %s", err.Error())
+		return ctx.Status(failoverStatus(err)).JSON(fiber.Map{
+			"result":    false,
+			"message":   err.Error(),
+			"triedBots": triedBots,
+		})
+	}
+
+	recordStore, _ := getLocal[*record_store.Store](ctx, "RECORD_STORE")
+	scheduleCarrierCleanup(recordStore, botName, usedBotName, results...)
+	recordFileAffinity(recordStore, botName, usedBotName, "album", results...)
+
+	response := fiber.Map{
+		"result":     true,
+		"items":      results,
+		"uploadedBy": usedBotName,
+		"triedBots":  triedBots,
+	}
+	if copies := fanOutAlbum(ctx.UserContext(), recordStore, botName, namedBots, fanOutChats(ctx, botScopeConfig, botName), items); len(copies) > 0 {
+		response["copies"] = copies
+	}
+
+	return ctx.Status(200).JSON(response)
+}
@@ -1,26 +1,21 @@
 package controllers
 
 import (
-	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
 	"go-uploader/config"
 	"go-uploader/models"
+	"go-uploader/pkg/record_store"
 	"go-uploader/pkg/telegram_api"
 	"go-uploader/utils"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
-	"slices"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/minio/minio-go/v7"
 )
 
 // getLocal safely retrieves a typed value from fiber context locals
@@ -169,7 +164,7 @@ func getContentTypeFromExtension(ext string) string {
 
 func DownloadFromTelegram(ctx *fiber.Ctx) error {
 	botName := ctx.Params("botName", "")
-	if !slices.Contains(utils.ValidBuckets, botName) {
+	if !utils.IsValidBucket(botName) {
 		return ctx.Status(400).JSON(models.GenericResponse{
 			Result:  false,
 			Message: "bot name is not valid",
@@ -188,6 +183,14 @@ func DownloadFromTelegram(ctx *fiber.Ctx) error {
 	specificBotFromURL := ctx.Params("specificBot", "")
 	specificBotFromQuery := ctx.Query("bot", "")
 
+	requestedStrategy := strings.ToLower(ctx.Query("strategy", ""))
+	if requestedStrategy != "" && !isValidStrategy(requestedStrategy) {
+		return ctx.Status(400).JSON(models.GenericResponse{
+			Result:  false,
+			Message: "strategy must be one of race, hedge, single",
+		})
+	}
+
 	minioClient, err := getLocal[*config.MinIOClients](ctx, "minio")
 	if err != nil {
 		return err
@@ -196,70 +199,13 @@ func DownloadFromTelegram(ctx *fiber.Ctx) error {
 	// ✅ IMPORTANT: Check MinIO cache first
 	log.Printf("🔍 Checking MinIO cache for FileID: %s in bucket: %s", fileId, botName)
 
-	cacheCtx, cancelCache := context.WithTimeout(ctx.UserContext(), 5*time.Second)
-	defer cancelCache()
-
-	objectInfo := minioClient.Storage.Conn().ListObjects(cacheCtx, botName, minio.ListObjectsOptions{
-		Prefix:    fileId,
-		Recursive: true,
-		UseV1:     true,
-	})
-
-	for info := range objectInfo {
-		if info.Size > 0 && strings.HasPrefix(info.Key, fileId) {
-			// File found in cache!
-			log.Printf("✅ Cache HIT for FileID: %s (Size: %d bytes, Key: %s)", fileId, info.Size, info.Key)
-
-			getCtx, cancelGet := context.WithTimeout(ctx.UserContext(), 10*time.Second)
-			defer cancelGet()
-
-			object, err := minioClient.Storage.Conn().GetObject(getCtx, botName, info.Key, minio.GetObjectOptions{})
-			if err != nil {
-				log.Printf("❌ Failed to get cached object: %v", err)
-				break // Continue to download from Telegram
-			}
-
-			// Get object info for content type
-			objInfo, err := object.Stat()
-			if err != nil {
-				log.Printf("⚠️ Failed to get object stat, continuing: %v", err)
-			}
-
-			data, readErr := io.ReadAll(io.LimitReader(object, maxDownloadSize))
-			_ = object.Close()
-
-			if readErr != nil {
-				log.Printf("❌ Failed to read cached object: %v", readErr)
-				break
-			}
-
-			if err != nil {
-				log.Printf("❌ Failed to read cached object: %v", err)
-				break
-			}
-
-			// Determine correct content type
-			extension := filepath.Ext(info.Key)
-			if extension != "" {
-				extension = strings.TrimPrefix(extension, ".")
-			}
-
-			contentType := getContentTypeFromExtension(extension)
-
-			// If we have metadata from MinIO, use it
-			if objInfo.ContentType != "" && !strings.Contains(objInfo.ContentType, "octet-stream") {
-				contentType = objInfo.ContentType
-			}
-
-			// ✅ Return from cache with correct content type
-			ctx.Set("Content-Type", contentType)
-			ctx.Set("X-Serve", "Cache")
-			ctx.Set("X-Cache", "HIT")
-			ctx.Set("Cache-Control", "public, max-age=86400")
-			ctx.Set("X-Cache-Key", info.Key)
-			log.Printf("🚀 Serving from cache: %s (%d bytes, type: %s)", fileId, len(data), contentType)
-			return ctx.Status(200).Send(data)
+	cache := newTelegramCache(minioClient, botName)
+	if key, found := cache.lookup(ctx.UserContext(), fileId); found {
+		log.Printf("✅ Cache HIT for FileID: %s (Key: %s)", fileId, key)
+		if err := serveCachedObject(ctx, cache, key); err == nil {
+			return nil
 		}
+		// Continue to download from Telegram
 	}
 
 	// ❌ Not in cache, download from Telegram
@@ -275,100 +221,55 @@ func DownloadFromTelegram(ctx *fiber.Ctx) error {
 
 	// Determine preferred bot name
 	preferredBotName := ""
-	useRacing := true
 
 	if specificBotFromURL != "" {
 		preferredBotName = specificBotFromURL
-		useRacing = false
 		log.Printf("🎯 Requested specific bot from URL: '%s'", preferredBotName)
 	} else if specificBotFromQuery != "" {
 		preferredBotName = specificBotFromQuery
-		useRacing = false
 		log.Printf("🎯 Requested specific bot from query: '%s'", preferredBotName)
+	}
+
+	strategy := getScopeStrategy(botName, requestedStrategy)
+	if preferredBotName != "" {
+		strategy = strategySpecific
 	} else {
-		log.Printf("🏁 No specific bot requested, using racing mode")
-	}
-
-	var fileData []byte
-	var resContentType string
-	var usedBotName string
-	var downloadBotName string
-
-	if useRacing {
-		// Use optimized racing mode for GetFile
-		filePath, selectedBotApi, winningBotName, err := raceGetFileWithNamesOptimized(namedBots, fileId)
-		if err != nil {
-			log.Printf("❌ raceGetFileWithNamesOptimized failed: %v", err)
-			// Try without optimization as fallback
-			filePath, selectedBotApi, winningBotName, err = raceGetFileWithNames(namedBots, fileId)
-			if err != nil {
-				return ctx.Status(500).JSON(models.GenericResponse{
-					Result:  false,
-					Message: "Failed to get file info from Telegram",
-				})
-			}
-		}
+		log.Printf("🏁 No specific bot requested, using %s mode", strategy)
+	}
 
-		// Debug logging for file path
-		log.Printf("📁 Raw file path from Telegram: %v (type: %T)", filePath, filePath)
-		if filePathStr, ok := filePath.(string); ok {
-			log.Printf("📁 Path contains 'video': %v", strings.Contains(filePathStr, "video"))
-			log.Printf("📁 Path contains 'document': %v", strings.Contains(filePathStr, "document"))
-			log.Printf("📁 Path contains 'photo': %v", strings.Contains(filePathStr, "photo"))
-			log.Printf("📁 Path contains 'animation': %v", strings.Contains(filePathStr, "animation"))
-		}
+	var info *telegram_api.FileInfo
+	var selectedBotApi *telegram_api.TelegramAPI
+	var resolvedBotName string
+	if preferredBotName != "" {
+		info, selectedBotApi, resolvedBotName, err = resolveTelegramFile(ctx.UserContext(), namedBots, fileId, preferredBotName, strategy)
+	} else {
+		// file_id فقط برای باتی که اون رو ساخته تضمینی هست؛ اول از همون بپرس
+		recordStore, _ := getLocal[*record_store.Store](ctx, "RECORD_STORE")
+		ownerBotName, _ := lookupFileAffinity(ctx.UserContext(), recordStore, botName, fileId)
+		info, selectedBotApi, resolvedBotName, err = resolveOwnedTelegramFile(ctx.UserContext(), namedBots, fileId, ownerBotName, strategy)
+	}
+	if err != nil {
+		return ctx.Status(500).JSON(models.GenericResponse{
+			Result:  false,
+			Message: err.Error(),
+		})
+	}
 
-		filePathStr, ok := filePath.(string)
-		if !ok {
-			return ctx.Status(500).JSON(models.GenericResponse{
-				Result:  false,
-				Message: "unexpected file path type from Telegram",
-			})
-		}
-		filePathString := selectedBotApi.Explode(filePathStr)
-		log.Printf("📁 After Explode: %s", filePathString)
-
-		// ⚡ مهم: اول با همون باتی که GetFile برنده شده دانلود کن
-		log.Printf("🎯 Using winner bot '%s' for download (no racing)", winningBotName)
-
-		fileData, resContentType, err = selectedBotApi.DownloadFile(filePathString)
-		if err != nil {
-			log.Printf("❌ Winner bot '%s' failed to download: %v", winningBotName, err)
-			log.Printf("🔄 Falling back to racing mode for download...")
-
-			// فقط اگه بات برنده fail شد، با بقیه racing کن
-			fileData, resContentType, downloadBotName, err = raceDownloadFileWithNamesOptimized(namedBots, filePathString)
-			if err != nil {
-				log.Printf("❌ Optimized racing also failed: %v", err)
-				// آخرین تلاش با racing معمولی
-				fileData, resContentType, downloadBotName, err = raceDownloadFileWithNames(namedBots, filePathString)
-				if err != nil {
-					log.Printf("❌ All download attempts failed")
-					return ctx.Status(500).JSON(models.GenericResponse{
-						Result:  false,
-						Message: "Failed to download from Telegram",
-					})
-				}
-			}
-			usedBotName = fmt.Sprintf("GetFile:%s|Download:%s", winningBotName, downloadBotName)
-		} else {
-			// بات برنده موفق شد
-			downloadBotName = winningBotName
-			usedBotName = winningBotName
-			log.Printf("✅ Winner bot '%s' successfully downloaded the file", winningBotName)
+	// همون فایل ممکنه با file_id یه بات دیگه قبلا کش شده باشه
+	if key, found := cache.findObject(ctx.UserContext(), info.FileUniqueId); found {
+		log.Printf("✅ Cache HIT by file_unique_id %s for FileID: %s (Key: %s)", info.FileUniqueId, fileId, key)
+		_ = cache.writeAlias(ctx.UserContext(), fileId, key)
+		if err := serveCachedObject(ctx, cache, key); err == nil {
+			return nil
 		}
+	}
 
-		log.Printf("✅ Complete download chain for FileID: %s", fileId)
-	} else {
-		// Use specific bot
-		fileData, resContentType, usedBotName, err = downloadFileWithSpecificBot(namedBots, preferredBotName, fileId)
-		if err != nil {
-			log.Printf("❌ downloadFileWithSpecificBot failed: %v", err)
-			return ctx.Status(500).JSON(models.GenericResponse{
-				Result:  false,
-				Message: "Failed to download with specific bot",
-			})
-		}
+	fileData, resContentType, usedBotName, err := downloadResolvedFile(ctx.UserContext(), namedBots, info, selectedBotApi, resolvedBotName, strategy)
+	if err != nil {
+		return ctx.Status(500).JSON(models.GenericResponse{
+			Result:  false,
+			Message: err.Error(),
+		})
 	}
 
 	// Determine the correct file extension and content type
@@ -380,31 +281,7 @@ func DownloadFromTelegram(ctx *fiber.Ctx) error {
 
 	// ✅ Upload to MinIO for future caching - in background
 	go func() {
-		uploadCtx, cancelUpload := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancelUpload()
-
-		file := bytes.NewReader(fileData)
-		fileName := fileId + "." + extension
-
-		log.Printf("📤 Uploading to MinIO cache: %s (size: %d bytes, type: %s)",
-			fileName, file.Size(), mimeType)
-
-		_, err := minioClient.Storage.Conn().PutObject(
-			uploadCtx,
-			botName,
-			fileName,
-			file,
-			file.Size(),
-			minio.PutObjectOptions{
-				ContentType: mimeType,
-			},
-		)
-
-		if err != nil {
-			log.Printf("❌ Failed to cache in MinIO: %v", err)
-		} else {
-			log.Printf("✅ Successfully cached in MinIO: %s", fileName)
-		}
+		_, _ = cache.store(fileId, info.FileUniqueId, extension, mimeType, fileData)
 	}()
 
 	// Return response immediately with correct content type
@@ -417,9 +294,27 @@ func DownloadFromTelegram(ctx *fiber.Ctx) error {
 	return ctx.Send(fileData)
 }
 
+// serveCachedObject writes a cached object as the response
+func serveCachedObject(ctx *fiber.Ctx, cache telegramCache, key string) error {
+	data, contentType, err := cache.read(ctx.UserContext(), key)
+	if err != nil {
+		log.Printf("❌ %v", err)
+		return err
+	}
+
+	// ✅ Return from cache with correct content type
+	ctx.Set("Content-Type", contentType)
+	ctx.Set("X-Serve", "Cache")
+	ctx.Set("X-Cache", "HIT")
+	ctx.Set("Cache-Control", "public, max-age=86400")
+	ctx.Set("X-Cache-Key", key)
+	log.Printf("🚀 Serving from cache: %s (%d bytes, type: %s)", key, len(data), contentType)
+	return ctx.Status(200).Send(data)
+}
+
 func UploadToTelegram(ctx *fiber.Ctx) error {
 	botName := ctx.Params("botName", "")
-	if !slices.Contains(utils.ValidBuckets, botName) {
+	if !utils.IsValidBucket(botName) {
 		return ctx.Status(400).JSON(models.GenericResponse{
 			Result:  false,
 			Message: "bot name is not valid",
@@ -478,26 +373,35 @@ func UploadToTelegram(ctx *fiber.Ctx) error {
 
 	contentType := http.DetectContentType(buf.Bytes())
 
-	// Use specific bot for upload (defaults to "relic" or first bot if preferredBotName is empty)
-	fileId, usedBotName, err := uploadFileWithSpecificBot(namedBots, preferredBotName, contentType, file.Filename, buf.Bytes(), os.Getenv("DEST_CHAT_ID"))
+	destChatId := botScopeConfig.GetDestChatId(botName)
+	if destChatId == "" {
+		return noDestChatResponse(ctx, botName)
+	}
+
+	// Use the requested bot, or route across the scope's bots with failover
+	upload, usedBotName, triedBots, err := uploadFileRouted(ctx.UserContext(), botName, namedBots, preferredBotName, contentType, file.Filename, buf.Bytes(), destChatId)
 	if err != nil {
 		log.Printf("Error Occurred -> %s", err.Error())
-		return ctx.Status(500).JSON(models.GenericResponse{
-			Result:  false,
-			Message: "Failed to upload with specific bot",
+		return ctx.Status(failoverStatus(err)).JSON(fiber.Map{
+			"result":    false,
+			"message":   err.Error(),
+			"triedBots": triedBots,
 		})
 	}
 
-	return ctx.Status(200).JSON(fiber.Map{
-		"result":     true,
-		"fileId":     fileId,
-		"uploadedBy": usedBotName,
-	})
+	recordStore, _ := getLocal[*record_store.Store](ctx, "RECORD_STORE")
+	scheduleCarrierCleanup(recordStore, botName, usedBotName, *upload)
+	recordFileAffinity(recordStore, botName, usedBotName, "upload", *upload)
+
+	// کپی روی کانال‌های پشتیبان، اگه کانال اصلی بن یا پاک بشه
+	copies := fanOutUpload(ctx.UserContext(), recordStore, botName, namedBots, fanOutChats(ctx, botScopeConfig, botName), upload, usedBotName, contentType, file.Filename, buf.Bytes())
+
+	return ctx.Status(200).JSON(withCopies(uploadResponse(upload, usedBotName, triedBots), upload, copies))
 }
 
 func UploadToTelegramViaLink(ctx *fiber.Ctx) error {
 	botName := ctx.Params("botName", "")
-	if !slices.Contains(utils.ValidBuckets, botName) {
+	if !utils.IsValidBucket(botName) {
 		return ctx.Status(400).JSON(models.GenericResponse{
 			Result:  false,
 			Message: "Bucket Not Found",
@@ -584,22 +488,46 @@ func UploadToTelegramViaLink(ctx *fiber.Ctx) error {
 		log.Printf("🎯 Requested specific bot from body: '%s'", preferredBotName)
 	}
 
-	// Use specific bot for upload (defaults to "relic" or first bot)
-	fileId, usedBotName, err := uploadFileWithSpecificBot(namedBots, preferredBotName, mimeType, fileName, resBody, os.Getenv("DEST_CHAT_ID"))
+	destChatId := botScopeConfig.GetDestChatId(botName)
+	if destChatId == "" {
+		return noDestChatResponse(ctx, botName)
+	}
+
+	// Use the requested bot, or route across the scope's bots with failover
+	upload, usedBotName, triedBots, err := uploadFileRouted(ctx.UserContext(), botName, namedBots, preferredBotName, mimeType, fileName, resBody, destChatId)
 	if err != nil {
 		log.Printf("Error Occurred -> %s", err.Error())
-		return ctx.Status(500).JSON(models.GenericResponse{
-			Result:  false,
-			Message: "Failed to upload with specific bot",
+		return ctx.Status(failoverStatus(err)).JSON(fiber.Map{
+			"result":    false,
+			"message":   err.Error(),
+			"triedBots": triedBots,
 		})
 	}
 
-	return ctx.Status(200).JSON(fiber.Map{
-		"result":     true,
-		"fileId":     fileId,
-		"uploadedBy": usedBotName,
-	})
+	recordStore, _ := getLocal[*record_store.Store](ctx, "RECORD_STORE")
+	scheduleCarrierCleanup(recordStore, botName, usedBotName, *upload)
+	recordFileAffinity(recordStore, botName, usedBotName, "upload", *upload)
+
+	// کپی روی کانال‌های پشتیبان، اگه کانال اصلی بن یا پاک بشه
+	copies := fanOutUpload(ctx.UserContext(), recordStore, botName, namedBots, fanOutChats(ctx, botScopeConfig, botName), upload, usedBotName, mimeType, fileName, resBody)
+
+	return ctx.Status(200).JSON(withCopies(uploadResponse(upload, usedBotName, triedBots), upload, copies))
+
+}
 
+// uploadResponse builds the JSON body returned by the Telegram upload endpoints
+func uploadResponse(upload *telegram_api.UploadResult, usedBotName string, triedBots []string) fiber.Map {
+	return fiber.Map{
+		"result":       true,
+		"fileId":       upload.FileId,
+		"fileUniqueId": upload.FileUniqueId,
+		"messageId":    upload.MessageId,
+		"chatId":       upload.ChatId,
+		"fileSize":     upload.FileSize,
+		"mimeType":     upload.MimeType,
+		"uploadedBy":   usedBotName,
+		"triedBots":    triedBots,
+	}
 }
 
 // ListBotScopes returns all available bot scopes with their named bots
@@ -614,5 +542,41 @@ func ListBotScopes(ctx *fiber.Ctx) error {
 	return ctx.Status(200).JSON(fiber.Map{
 		"result": true,
 		"scopes": scopeDetails,
+		"bots":   botScopeConfig.GetScopeBotStatuses(),
+	})
+}
+
+// BotScopeStats returns live latency and error statistics of every bot
+func BotScopeStats(ctx *fiber.Ctx) error {
+	botScopeConfig, err := getLocal[*config.BotScopeConfiguration](ctx, "BOT_SCOPE_CONFIG")
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(200).JSON(fiber.Map{
+		"result": true,
+		"stats":  botScopeConfig.GetScopeBotStats(),
+	})
+}
+
+// RefreshBotScopes re-validates every bot token with getMe
+func RefreshBotScopes(ctx *fiber.Ctx) error {
+	botScopeConfig, err := getLocal[*config.BotScopeConfiguration](ctx, "BOT_SCOPE_CONFIG")
+	if err != nil {
+		return err
+	}
+
+	log.Printf("🔄 Refreshing bot identities via getMe")
+	errs := botScopeConfig.ValidateBots(ctx.UserContext())
+
+	failures := make([]string, len(errs))
+	for i, validateErr := range errs {
+		failures[i] = validateErr.Error()
+	}
+
+	return ctx.Status(200).JSON(fiber.Map{
+		"result":   true,
+		"failures": failures,
+		"bots":     botScopeConfig.GetScopeBotStatuses(),
 	})
 }
\ No newline at end of file
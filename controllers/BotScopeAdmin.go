@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"errors"
+	"go-uploader/config"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ScopeRequest creates a scope
+type ScopeRequest struct {
+	Name          string                `json:"name"`
+	DestChatId    string                `json:"destChatId"`
+	BackupChatIds []string              `json:"backupChatIds"`
+	Strategy      string                `json:"strategy"`
+	UploadRouting string                `json:"uploadRouting"`
+	Bots          []config.BotFileEntry `json:"bots"`
+}
+
+// ScopeUpdateRequest renames a scope or changes its settings; omitted fields are kept
+type ScopeUpdateRequest struct {
+	Name          string    `json:"name"`
+	DestChatId    *string   `json:"destChatId"`
+	BackupChatIds *[]string `json:"backupChatIds"`
+	Strategy      *string   `json:"strategy"`
+	UploadRouting *string   `json:"uploadRouting"`
+}
+
+// ScopeBotRequest adds a bot or changes its name or disabled flag
+type ScopeBotRequest struct {
+	Name     string `json:"name"`
+	Token    string `json:"token"`
+	Disabled *bool  `json:"disabled"`
+}
+
+// applyBotScopeChange runs an admin change and responds with the resulting scopes
+func applyBotScopeChange(ctx *fiber.Ctx, action string, change func(file *config.BotScopeFile) error) error {
+	botScopeConfig, persisted, err := config.UpdateBotScopes(ctx.UserContext(), change)
+	if err != nil {
+		log.Printf("❌ Bot scope change '%s' rejected: %v", action, err)
+
+		status := 500
+		switch {
+		case errors.Is(err, config.ErrScopeNotFound), errors.Is(err, config.ErrBotNotFound):
+			status = 404
+		case errors.Is(err, config.ErrScopeExists), errors.Is(err, config.ErrBotExists):
+			status = 409
+		case errors.Is(err, config.ErrInvalidBotScopes):
+			status = 400
+		}
+		return ctx.Status(status).JSON(fiber.Map{
+			"result":  false,
+			"message": err.Error(),
+		})
+	}
+
+	log.Printf("🛠️ Bot scope change applied: %s", action)
+	return ctx.Status(200).JSON(fiber.Map{
+		"result":    true,
+		"message":   action,
+		"persisted": persisted,
+		"scopes":    botScopeConfig.GetAllScopeDetails(),
+		"bots":      botScopeConfig.GetScopeBotStatuses(),
+	})
+}
+
+// CreateBotScope adds a scope, optionally with its bots
+func CreateBotScope(ctx *fiber.Ctx) error {
+	var req ScopeRequest
+	if err := ctx.BodyParser(&req); err != nil || req.Name == "" {
+		return ctx.Status(400).JSON(fiber.Map{
+			"result":  false,
+			"message": "Invalid request body, name is required",
+		})
+	}
+
+	return applyBotScopeChange(ctx, "scope '"+req.Name+"' created", func(file *config.BotScopeFile) error {
+		return file.AddScope(req.Name, config.ScopeFileEntry{
+			DestChatId:    req.DestChatId,
+			BackupChatIds: req.BackupChatIds,
+			Strategy:      req.Strategy,
+			UploadRouting: req.UploadRouting,
+			Bots:          req.Bots,
+		})
+	})
+}
+
+// UpdateBotScope renames a scope and/or changes its settings
+func UpdateBotScope(ctx *fiber.Ctx) error {
+	scope := ctx.Params("scope")
+
+	var req ScopeUpdateRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{
+			"result":  false,
+			"message": "Invalid request body",
+		})
+	}
+
+	newName := scope
+	if req.Name != "" {
+		newName = req.Name
+	}
+
+	return applyBotScopeChange(ctx, "scope '"+scope+"' updated", func(file *config.BotScopeFile) error {
+		if newName != scope {
+			if err := file.RenameScope(scope, newName); err != nil {
+				return err
+			}
+		}
+		return file.UpdateScope(newName, func(entry *config.ScopeFileEntry) {
+			if req.DestChatId != nil {
+				entry.DestChatId = *req.DestChatId
+			}
+			if req.BackupChatIds != nil {
+				entry.BackupChatIds = *req.BackupChatIds
+			}
+			if req.Strategy != nil {
+				entry.Strategy = *req.Strategy
+			}
+			if req.UploadRouting != nil {
+				entry.UploadRouting = *req.UploadRouting
+			}
+		})
+	})
+}
+
+// DeleteBotScope removes a scope and its bots
+func DeleteBotScope(ctx *fiber.Ctx) error {
+	scope := ctx.Params("scope")
+
+	return applyBotScopeChange(ctx, "scope '"+scope+"' deleted", func(file *config.BotScopeFile) error {
+		return file.RemoveScope(scope)
+	})
+}
+
+// AddScopeBot adds a bot to a scope after its token passes getMe
+func AddScopeBot(ctx *fiber.Ctx) error {
+	scope := ctx.Params("scope")
+
+	var req ScopeBotRequest
+	if err := ctx.BodyParser(&req); err != nil || req.Name == "" || req.Token == "" {
+		return ctx.Status(400).JSON(fiber.Map{
+			"result":  false,
+			"message": "Invalid request body, name and token are required",
+		})
+	}
+
+	bot := config.BotFileEntry{Name: req.Name, Token: req.Token}
+	if req.Disabled != nil {
+		bot.Disabled = *req.Disabled
+	}
+
+	return applyBotScopeChange(ctx, "bot '"+req.Name+"' added to scope '"+scope+"'", func(file *config.BotScopeFile) error {
+		return file.AddBot(scope, bot)
+	})
+}
+
+// UpdateScopeBot renames, disables or enables a bot of a scope
+func UpdateScopeBot(ctx *fiber.Ctx) error {
+	scope := ctx.Params("scope")
+	botName := ctx.Params("bot")
+
+	var req ScopeBotRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{
+			"result":  false,
+			"message": "Invalid request body",
+		})
+	}
+	if req.Token != "" {
+		return ctx.Status(400).JSON(fiber.Map{
+			"result":  false,
+			"message": "Token cannot be changed, remove the bot and add it again",
+		})
+	}
+
+	return applyBotScopeChange(ctx, "bot '"+botName+"' in scope '"+scope+"' updated", func(file *config.BotScopeFile) error {
+		if req.Disabled != nil {
+			if err := file.UpdateBot(scope, botName, func(bot *config.BotFileEntry) { bot.Disabled = *req.Disabled }); err != nil {
+				return err
+			}
+		}
+		if req.Name != "" && req.Name != botName {
+			return file.RenameBot(scope, botName, req.Name)
+		}
+		return nil
+	})
+}
+
+// DeleteScopeBot removes a bot from a scope
+func DeleteScopeBot(ctx *fiber.Ctx) error {
+	scope := ctx.Params("scope")
+	botName := ctx.Params("bot")
+
+	return applyBotScopeChange(ctx, "bot '"+botName+"' removed from scope '"+scope+"'", func(file *config.BotScopeFile) error {
+		return file.RemoveBot(scope, botName)
+	})
+}
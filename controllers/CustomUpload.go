@@ -13,7 +13,6 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/url"
-	"slices"
 	"strings"
 	"time"
 )
@@ -119,7 +118,7 @@ func DownloadFromLinkAndUpload(ctx *fiber.Ctx) error {
 		return ctx.Status(400).JSON(models.GenericResponse{Result: false, Message: err.Error()})
 	}
 
-	if !slices.Contains(utils.ValidBuckets, body.Bucket) {
+	if !utils.IsValidBucket(body.Bucket) {
 		return ctx.Status(400).JSON(models.GenericResponse{
 			Result:  false,
 			Message: "Bucket Not Found",
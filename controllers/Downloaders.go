@@ -4,23 +4,21 @@ import (
 	"archive/zip"
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go-uploader/config"
 	"go-uploader/models"
 	"go-uploader/pkg/instagram_api"
+	"go-uploader/pkg/record_store"
 	"go-uploader/utils"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
 	"slices"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -44,7 +42,7 @@ func DownloadFile(ctx *fiber.Ctx) error {
 	}
 
 	bucket := reqPath[2]
-	if !slices.Contains(utils.ValidBuckets, bucket) {
+	if !utils.IsValidBucket(bucket) {
 		return ctx.Status(403).JSON(models.GenericResponse{
 			Result:  false,
 			Message: "Access denied: invalid bucket",
@@ -408,466 +406,122 @@ func DownloadProfile(ctx *fiber.Ctx) error {
 }
 
 func ZipMultipleFiles(ctx *fiber.Ctx) error {
-	contentType := ctx.Get("Content-Type")
-	if contentType != "text/plain" {
-		return ctx.Status(400).JSON(models.GenericResponse{
-			Result:  false,
-			Message: fmt.Sprintf("Content-Type %s not supported", contentType),
-		})
-	}
-
-	bodyBase64 := ctx.Body()
-	bodyRaw, err := base64.StdEncoding.DecodeString(string(bodyBase64))
-	if err != nil {
-		return ctx.Status(500).JSON(models.GenericResponse{
-			Result:  false,
-			Message: err.Error(),
-		})
-	}
-
-	var requestData [][]string
-	if err = json.Unmarshal(bodyRaw, &requestData); err != nil {
-		return ctx.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
-			Result:  false,
-			Message: err.Error(),
-		})
-	}
+	return zipMultipleFiles(ctx, zipBuildOptions{
+		Attempts: 1,
+		Method:   zip.Deflate,
+	})
+}
 
-	const maxZipFiles = 50
-	if len(requestData) > maxZipFiles {
-		return ctx.Status(400).JSON(models.GenericResponse{
-			Result:  false,
-			Message: fmt.Sprintf("too many files: %d exceeds maximum of %d", len(requestData), maxZipFiles),
-		})
-	}
+// ZipMultipleFilesOptimized is a high-performance version with additional optimizations
+func ZipMultipleFilesOptimized(ctx *fiber.Ctx) error {
+	return zipMultipleFiles(ctx, optimizedZipOptions())
+}
 
-	// Validate input data early - حداقل 2 المان باید باشه (botName, fileId)
-	// المان سوم (username) اختیاری هست
-	for _, data := range requestData {
-		if len(data) < 2 {
-			return ctx.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
-				Result:  false,
-				Message: "data format error: each item needs at least [botName, fileId]",
-			})
-		}
+// optimizedZipOptions are the limits of /zip/multi/optimized, also used by zip jobs and encrypted archives
+func optimizedZipOptions() zipBuildOptions {
+	return zipBuildOptions{
+		// Limit concurrent downloads to prevent resource exhaustion
+		MaxConcurrent: 10,
+		// Try up to 2 times
+		Attempts: 2,
+		Method:   zip.Deflate,
 	}
+}
 
-	archiveName := fmt.Sprintf("%x.zip", sha256.Sum256(bodyBase64))
-
-	// Set response headers immediately for streaming
-	ctx.Set("Content-Type", "application/zip")
-	ctx.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", archiveName))
-	ctx.Set("Transfer-Encoding", "chunked")
-
-	// Create a pipe for streaming the zip directly to the response
-	pipeReader, pipeWriter := io.Pipe()
-	zipWriter := zip.NewWriter(pipeWriter)
-
-	// Channel for coordinating file download and zip writing
-	type fileResult struct {
-		fileID      string
-		fileName    string // username برای نام‌گذاری فایل
-		fileData    []byte
-		contentType string
-		extension   string
-		err         error
+// zipRequestError answers a rejected zip request with the status its error carries, 500 otherwise
+func zipRequestError(ctx *fiber.Ctx, err error) error {
+	status := 500
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status = fiberErr.Code
 	}
-
-	fileResultChan := make(chan fileResult, len(requestData))
-	var downloadWg sync.WaitGroup
-
-	// Start concurrent downloads
-	for _, data := range requestData {
-		botName := data[0]
-		fileID := data[1]
-
-		// اگه username داده شده از اون استفاده کن، وگرنه از fileID
-		fileName := fileID
-		if len(data) >= 3 && data[2] != "" {
-			fileName = data[2]
-		}
-
-		botAPIs := selectBotAPI(ctx, strings.ToLower(botName))
-
-		downloadWg.Add(1)
-		go func(fileID, fileName, botName string) {
-			defer downloadWg.Done()
-
-			// Download file with racing
-			filePath, selectedBotAPI, err := raceGetFile(botAPIs, fileID)
-			if err != nil {
-				fileResultChan <- fileResult{fileID: fileID, fileName: fileName, err: err}
-				return
-			}
-
-			filePathStr, ok := filePath.(string)
-			if !ok {
-				fileResultChan <- fileResult{fileID: fileID, fileName: fileName, err: fmt.Errorf("unexpected filePath type: %T", filePath)}
-				return
-			}
-
-			fileData, resContentType, err := raceDownloadFile(botAPIs, selectedBotAPI.Explode(filePathStr))
-			if err != nil {
-				fileResultChan <- fileResult{fileID: fileID, fileName: fileName, err: err}
-				return
-			}
-
-			// Determine file extension
-			mimeType := http.DetectContentType(fileData)
-			if strings.Contains(mimeType, "text/plain") {
-				mimeType = resContentType
-			}
-
-			fileExtension := "bin"
-			if parts := strings.Split(mimeType, "/"); len(parts) == 2 {
-				fileExtension = parts[1]
-			}
-
-			fileResultChan <- fileResult{
-				fileID:      fileID,
-				fileName:    fileName,
-				fileData:    fileData,
-				contentType: mimeType,
-				extension:   fileExtension,
-				err:         nil,
-			}
-		}(fileID, fileName, botName)
-	}
-
-	// Goroutine to close the channel when all downloads are done
-	go func() {
-		downloadWg.Wait()
-		close(fileResultChan)
-	}()
-
-	// Goroutine to write files to zip as they become available
-	go func() {
-		defer func() {
-			_ = zipWriter.Close()
-			_ = pipeWriter.Close()
-		}()
-
-		filesProcessed := 0
-		for result := range fileResultChan {
-			if result.err != nil {
-				log.Printf("Error downloading file %s: %v", result.fileID, result.err)
-				// Write error to pipe to terminate the stream
-				_ = pipeWriter.CloseWithError(result.err)
-				return
-			}
-
-			// Create zip entry and write file data - استفاده از fileName به جای fileID
-			safeName := filepath.Base(result.fileName)
-			zipFileWriter, err := zipWriter.Create(safeName + "." + result.extension)
-			if err != nil {
-				log.Printf("Error creating zip entry for %s: %v", result.fileName, err)
-				_ = pipeWriter.CloseWithError(err)
-				return
-			}
-
-			if _, err := zipFileWriter.Write(result.fileData); err != nil {
-				log.Printf("Error writing file data for %s: %v", result.fileName, err)
-				_ = pipeWriter.CloseWithError(err)
-				return
-			}
-
-			filesProcessed++
-			log.Printf("Added file %s (ID: %s) to zip (%d/%d)", result.fileName, result.fileID, filesProcessed, len(requestData))
-		}
-
-		log.Printf("Successfully processed all %d files for zip", filesProcessed)
-	}()
-
-	// Stream the zip data directly to the client
-	ctx.Context().SetBodyStream(pipeReader, -1)
-	return nil
+	return ctx.Status(status).JSON(models.GenericResponse{
+		Result:  false,
+		Message: err.Error(),
+	})
 }
 
-// ZipMultipleFilesOptimized is a high-performance version with additional optimizations
-func ZipMultipleFilesOptimized(ctx *fiber.Ctx) error {
-	contentType := ctx.Get("Content-Type")
-	if contentType != "text/plain" {
-		return ctx.Status(400).JSON(models.GenericResponse{
-			Result:  false,
-			Message: fmt.Sprintf("Content-Type %s not supported", contentType),
-		})
+// zipMultipleFiles parses a zip request and streams the archive built with opts
+func zipMultipleFiles(ctx *fiber.Ctx, opts zipBuildOptions) error {
+	entries, requestHash, err := parseZipRequest(ctx)
+	if err == nil {
+		opts.Mode, err = parseZipMode(ctx)
 	}
-
-	bodyBase64 := ctx.Body()
-	bodyRaw, err := base64.StdEncoding.DecodeString(string(bodyBase64))
-	if err != nil {
-		return ctx.Status(500).JSON(models.GenericResponse{
-			Result:  false,
-			Message: err.Error(),
-		})
+	if err == nil {
+		opts.Format, err = parseArchiveFormat(ctx)
 	}
-
-	var requestData [][]string
-	if err = json.Unmarshal(bodyRaw, &requestData); err != nil {
-		return ctx.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
-			Result:  false,
-			Message: err.Error(),
-		})
+	generatedPassword := false
+	if err == nil {
+		opts.Password, generatedPassword, err = parseZipPassword(ctx, opts.Format)
 	}
-
-	const maxZipFilesOpt = 50
-	if len(requestData) > maxZipFilesOpt {
-		return ctx.Status(400).JSON(models.GenericResponse{
-			Result:  false,
-			Message: fmt.Sprintf("too many files: %d exceeds maximum of %d", len(requestData), maxZipFilesOpt),
-		})
+	if err != nil {
+		return zipRequestError(ctx, err)
 	}
 
-	// Validate input data early - حداقل 2 المان باید باشه (botName, fileId)
-	// المان سوم (username) اختیاری هست
-	for _, data := range requestData {
-		if len(data) < 2 {
-			return ctx.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
-				Result:  false,
-				Message: "data format error: each item needs at least [botName, fileId]",
-			})
+	if opts.Password != "" {
+		// رمزنگاری CPU می‌خواد، پس همون محدودیت‌های optimized اعمال میشه
+		limits := optimizedZipOptions()
+		opts.MaxConcurrent, opts.Attempts = limits.MaxConcurrent, limits.Attempts
+		if generatedPassword {
+			ctx.Set(zipPasswordHeader, opts.Password)
 		}
 	}
 
-	// Limit concurrent downloads to prevent resource exhaustion
-	const maxConcurrentDownloads = 10
-	semaphore := make(chan struct{}, maxConcurrentDownloads)
-
-	archiveName := fmt.Sprintf("%x.zip", sha256.Sum256(bodyBase64))
-
-	// Set response headers immediately for streaming
-	ctx.Set("Content-Type", "application/zip")
-	ctx.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", archiveName))
-	ctx.Set("Transfer-Encoding", "chunked")
-	ctx.Set("Cache-Control", "no-cache")
-
-	// Create a buffered pipe for better performance
-	pipeReader, pipeWriter := io.Pipe()
-
-	zipWriter := zip.NewWriter(pipeWriter)
-
-	// Channel for coordinating file download and zip writing
-	type fileResult struct {
-		fileID      string
-		fileName    string // username برای نام‌گذاری فایل
-		fileData    []byte
-		contentType string
-		extension   string
-		size        int64
-		err         error
+	botScopeConfig, err := getLocal[*config.BotScopeConfiguration](ctx, "BOT_SCOPE_CONFIG")
+	if err != nil {
+		return err
 	}
+	recordStore, _ := getLocal[*record_store.Store](ctx, "RECORD_STORE")
+	minioClient, _ := getLocal[*config.MinIOClients](ctx, "minio")
 
-	fileResultChan := make(chan fileResult, len(requestData))
-	var downloadWg sync.WaitGroup
-
-	totalFiles := len(requestData)
-
-	log.Printf("Starting ZIP creation for %d files", totalFiles)
-
-	// Start concurrent downloads with rate limiting
-	for i, data := range requestData {
-		botName := data[0]
-		fileID := data[1]
-
-		// اگه username داده شده از اون استفاده کن، وگرنه از fileID
-		fileName := fileID
-		if len(data) >= 3 && data[2] != "" {
-			fileName = data[2]
-		}
-
-		downloadWg.Add(1)
-		go func(fileID, fileName, botName string, index int) {
-			defer downloadWg.Done()
-
-			// Acquire semaphore slot
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
-
-			log.Printf("Starting download %d/%d: %s (name: %s)", index+1, totalFiles, fileID, fileName)
-
-			botAPIs := selectBotAPI(ctx, strings.ToLower(botName))
-			if len(botAPIs) == 0 {
-				fileResultChan <- fileResult{fileID: fileID, fileName: fileName, err: fmt.Errorf("no bot APIs available for %s", botName)}
-				return
-			}
-
-			// Download with timeout and retry logic
-			var fileData []byte
-			var resContentType string
-			var downloadErr error
-
-			// Try up to 2 times
-			for attempt := 1; attempt <= 2; attempt++ {
-				// Get file path with racing
-				filePath, selectedBotAPI, err := raceGetFile(botAPIs, fileID)
-				if err != nil {
-					downloadErr = err
-					if attempt == 2 {
-						break
-					}
-					log.Printf("Attempt %d failed for %s, retrying: %v", attempt, fileID, err)
-					time.Sleep(time.Millisecond * 100)
-					continue
-				}
-
-				// Download file data with racing
-				filePathStr, ok := filePath.(string)
-				if !ok {
-					downloadErr = fmt.Errorf("unexpected filePath type: %T", filePath)
-					break
-				}
-				fileData, resContentType, err = raceDownloadFile(botAPIs, selectedBotAPI.Explode(filePathStr))
-				if err != nil {
-					downloadErr = err
-					if attempt == 2 {
-						break
-					}
-					log.Printf("Download attempt %d failed for %s, retrying: %v", attempt, fileID, err)
-					time.Sleep(time.Millisecond * 100)
-					continue
-				}
-
-				// Success
-				downloadErr = nil
-				break
-			}
-
-			if downloadErr != nil {
-				fileResultChan <- fileResult{fileID: fileID, fileName: fileName, err: downloadErr}
-				return
-			}
-
-			// Determine file extension
-			mimeType := http.DetectContentType(fileData)
-			if strings.Contains(mimeType, "text/plain") && resContentType != "" {
-				mimeType = resContentType
-			}
-
-			fileExtension := "bin" // default
-			if parts := strings.Split(mimeType, "/"); len(parts) == 2 {
-				fileExtension = parts[1]
-			}
-
-			log.Printf("Download completed %d/%d: %s as %s (%d bytes)", index+1, totalFiles, fileID, fileName, len(fileData))
-
-			fileResultChan <- fileResult{
-				fileID:      fileID,
-				fileName:    fileName,
-				fileData:    fileData,
-				contentType: mimeType,
-				extension:   fileExtension,
-				size:        int64(len(fileData)),
-				err:         nil,
-			}
-		}(fileID, fileName, botName, i)
+	builder := zipBuilder{
+		endpoint:       strings.Clone(ctx.Path()),
+		minioClient:    minioClient,
+		botScopeConfig: botScopeConfig,
+		recordStore:    recordStore,
+		opts:           opts,
 	}
+	return streamZip(ctx, builder, opts.Format.fileName(requestHash), entries)
+}
 
-	// Goroutine to close the channel when all downloads are done
-	go func() {
-		downloadWg.Wait()
-		close(fileResultChan)
-	}()
-
-	// Goroutine to write files to zip as they become available
-	zipWriteComplete := make(chan error, 1)
-	go func() {
-		defer func() {
-			if err := zipWriter.Close(); err != nil {
-				log.Printf("Error closing zip writer: %v", err)
-			}
-			if err := pipeWriter.Close(); err != nil {
-				log.Printf("Error closing pipe writer: %v", err)
-			}
-		}()
-
-		filesProcessed := 0
-		totalSize := int64(0)
-
-		for result := range fileResultChan {
-			if result.err != nil {
-				log.Printf("Error downloading file %s: %v", result.fileID, result.err)
-				zipWriteComplete <- result.err
-				return
-			}
+// GetZipPerformanceInfo returns telemetry of the recent archive builds.
+// Optional query: from/to (RFC3339) or since (e.g. 1h), scope, limit (recent builds listed)
+func GetZipPerformanceInfo(ctx *fiber.Ctx) error {
+	var filter zipTelemetryFilter
+	var err error
 
-			// Create zip entry with optimized compression - استفاده از fileName
-			safeName := filepath.Base(result.fileName)
-			zipFileWriter, err := zipWriter.CreateHeader(&zip.FileHeader{
-				Name:   safeName + "." + result.extension,
-				Method: zip.Deflate, // Use compression
+	if since := ctx.Query("since"); since != "" {
+		var window time.Duration
+		if window, err = time.ParseDuration(since); err != nil || window <= 0 {
+			return ctx.Status(400).JSON(models.GenericResponse{
+				Result:  false,
+				Message: "since must be a positive duration, e.g. 1h",
 			})
-			if err != nil {
-				log.Printf("Error creating zip entry for %s: %v", result.fileName, err)
-				zipWriteComplete <- err
-				return
-			}
-
-			// Write file data in chunks for better memory usage
-			chunkSize := 32 * 1024 // 32KB chunks
-			reader := bytes.NewReader(result.fileData)
-			written, err := io.CopyBuffer(zipFileWriter, reader, make([]byte, chunkSize))
-			if err != nil {
-				log.Printf("Error writing file data for %s: %v", result.fileName, err)
-				zipWriteComplete <- err
-				return
-			}
-
-			filesProcessed++
-			totalSize += result.size
-			log.Printf("Added file %s (ID: %s) to zip (%d/%d) - %d bytes, total: %d bytes",
-				result.fileName, result.fileID, filesProcessed, totalFiles, written, totalSize)
 		}
-
-		log.Printf("Successfully processed all %d files for zip, total size: %d bytes", filesProcessed, totalSize)
-		zipWriteComplete <- nil
-	}()
-
-	// Monitor the zip writing process
-	go func() {
-		if err := <-zipWriteComplete; err != nil {
-			_ = pipeWriter.CloseWithError(err)
+		filter.From = time.Now().Add(-window)
+	}
+	for param, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := ctx.Query(param); v != "" {
+			if *target, err = time.Parse(time.RFC3339, v); err != nil {
+				return ctx.Status(400).JSON(models.GenericResponse{
+					Result:  false,
+					Message: param + " must be an RFC3339 time",
+				})
+			}
 		}
-	}()
-
-	// Stream the zip data directly to the client
-	ctx.Context().SetBodyStream(pipeReader, -1)
-	return nil
-}
-
-// ZipPerformanceStats tracks performance metrics for ZIP operations
-type ZipPerformanceStats struct {
-	StartTime       time.Time `json:"start_time"`
-	TotalFiles      int       `json:"total_files"`
-	FilesProcessed  int       `json:"files_processed"`
-	TotalSize       int64     `json:"total_size"`
-	AverageFileSize int64     `json:"average_file_size"`
-	Duration        string    `json:"duration"`
-	ThroughputMBps  float64   `json:"throughput_mbps"`
-	SuccessRate     float64   `json:"success_rate"`
-	ConcurrentLimit int       `json:"concurrent_limit"`
-}
-
-// GetZipPerformanceInfo returns performance information for ZIP operations
-func GetZipPerformanceInfo(ctx *fiber.Ctx) error {
-	// This would be expanded to track actual performance metrics
-	// For now, it returns configuration information
-	stats := ZipPerformanceStats{
-		StartTime:       time.Now(),
-		ConcurrentLimit: 10, // Current limit from optimized version
 	}
+	filter.Scope = strings.ToLower(ctx.Query("scope"))
+
+	records := getZipTelemetry().list(filter)
+	limit := min(max(ctx.QueryInt("limit", 20), 0), len(records))
 
 	return ctx.JSON(fiber.Map{
 		"result": true,
-		"stats":  stats,
-		"recommendations": []string{
-			"Use /zip/multi/optimized for better performance",
-			"Limit concurrent requests to prevent resource exhaustion",
-			"Consider implementing caching for frequently requested files",
-			"Monitor memory usage with large file sets",
-		},
+		"stats":  summarizeZipTelemetry(records, filter),
+		"recent": records[:limit],
 		"data_format": []string{
 			"[botName, fileId] - اسم فایل با fileId ذخیره میشه",
 			"[botName, fileId, username] - اسم فایل با username ذخیره میشه",
 		},
 	})
-}
\ No newline at end of file
+}
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"go-uploader/config"
+	"go-uploader/pkg/record_store"
 	"go-uploader/pkg/telegram_api"
 	"log"
 	"path/filepath"
@@ -88,21 +89,31 @@ func TransferFileId(ctx *fiber.Ctx) error {
 		log.Printf("🎯 Requested specific destination bot: '%s'", req.PreferredBotName)
 	}
 
-	// Use specific bot for upload (defaults to "relic" or first bot)
-	finalFileId, usedBotName, err := uploadFileWithSpecificBot(destNamedBots, req.PreferredBotName, contentType, fileName, fileData, req.ChatId)
+	// Use the requested bot, or route across the scope's bots with failover
+	upload, usedBotName, triedBots, err := uploadFileRouted(ctx.UserContext(), req.BotName, destNamedBots, req.PreferredBotName, contentType, fileName, fileData, req.ChatId)
 	if err != nil {
 		log.Printf("❌ Failed to upload file: %s -> %v", fileName, err.Error())
-		return ctx.Status(500).JSON(fiber.Map{
-			"result":  false,
-			"message": "Failed to Upload requested file to specific bot",
+		return ctx.Status(failoverStatus(err)).JSON(fiber.Map{
+			"result":    false,
+			"message":   "Failed to Upload requested file to specific bot",
+			"triedBots": triedBots,
 		})
 	}
 
-	log.Printf("✅ Transfer completed: FileID %s transferred to bot '%s' -> New FileID: %s", req.FileId, usedBotName, finalFileId)
+	log.Printf("✅ Transfer completed: FileID %s transferred to bot '%s' -> New FileID: %s", req.FileId, usedBotName, upload.FileId)
+
+	recordStore, _ := getLocal[*record_store.Store](ctx, "RECORD_STORE")
+	recordFileAffinity(recordStore, req.BotName, usedBotName, "transfer", *upload)
 
 	return ctx.Status(200).JSON(fiber.Map{
 		"result":        true,
-		"fileId":        finalFileId,
+		"fileId":        upload.FileId,
+		"fileUniqueId":  upload.FileUniqueId,
+		"messageId":     upload.MessageId,
+		"chatId":        upload.ChatId,
+		"fileSize":      upload.FileSize,
+		"mimeType":      upload.MimeType,
 		"transferredBy": usedBotName,
+		"triedBots":     triedBots,
 	})
 }
@@ -0,0 +1,350 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"go-uploader/config"
+	"go-uploader/models"
+	"go-uploader/pkg/record_store"
+	"go-uploader/pkg/telegram_api"
+	"go-uploader/utils"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const webhookPrefix = "webhook/"
+
+// WebhookFileRecord maps a Telegram message to a file archived from it
+type WebhookFileRecord struct {
+	Scope        string     `json:"scope"`
+	ChatId       int64      `json:"chatId"`
+	ChatTitle    string     `json:"chatTitle,omitempty"`
+	MessageId    int64      `json:"messageId"`
+	MediaGroupId string     `json:"mediaGroupId,omitempty"`
+	Kind         string     `json:"kind"`
+	FileId       string     `json:"fileId"`
+	FileUniqueId string     `json:"fileUniqueId"`
+	FileName     string     `json:"fileName,omitempty"`
+	MimeType     string     `json:"mimeType,omitempty"`
+	FileSize     int64      `json:"fileSize,omitempty"`
+	Status       string     `json:"status"`
+	ObjectKey    string     `json:"objectKey,omitempty"`
+	ArchivedBy   string     `json:"archivedBy,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	ReceivedAt   time.Time  `json:"receivedAt"`
+	ArchivedAt   *time.Time `json:"archivedAt,omitempty"`
+}
+
+func (r WebhookFileRecord) key() string {
+	id := r.FileUniqueId
+	if id == "" {
+		id = r.FileId
+	}
+	return fmt.Sprintf("%s%s/%d/%d/%s.json", webhookPrefix, r.Scope, r.ChatId, r.MessageId, id)
+}
+
+// webhookArchiveJob is one webhook file waiting to be archived
+type webhookArchiveJob struct {
+	minioClient    *config.MinIOClients
+	botScopeConfig *config.BotScopeConfiguration
+	recordStore    *record_store.Store
+	record         WebhookFileRecord
+	receivingBot   string
+}
+
+var (
+	webhookArchiveQueue     chan webhookArchiveJob
+	webhookArchiveQueueOnce sync.Once
+)
+
+// envPositiveInt reads a positive integer from env, falling back to defaultValue
+func envPositiveInt(key string, defaultValue int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+// enqueueWebhookArchive hands a file to the WEBHOOK_ARCHIVE_WORKERS (default 4) archive
+// workers. At most WEBHOOK_ARCHIVE_QUEUE (default 256) files wait; false means it was full.
+func enqueueWebhookArchive(job webhookArchiveJob) bool {
+	webhookArchiveQueueOnce.Do(func() {
+		webhookArchiveQueue = make(chan webhookArchiveJob, envPositiveInt("WEBHOOK_ARCHIVE_QUEUE", 256))
+		for range envPositiveInt("WEBHOOK_ARCHIVE_WORKERS", 4) {
+			go func() {
+				for job := range webhookArchiveQueue {
+					archiveWebhookFile(job.minioClient, job.botScopeConfig, job.recordStore, job.record, job.receivingBot)
+				}
+			}()
+		}
+	})
+
+	select {
+	case webhookArchiveQueue <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// getWebhookSecret returns TELEGRAM_WEBHOOK_SECRET_<SCOPE>, falling back to TELEGRAM_WEBHOOK_SECRET
+func getWebhookSecret(scope string) string {
+	if secret := os.Getenv("TELEGRAM_WEBHOOK_SECRET_" + strings.ToUpper(scope)); secret != "" {
+		return secret
+	}
+	return os.Getenv("TELEGRAM_WEBHOOK_SECRET")
+}
+
+// TelegramWebhook receives updates for a scope's bots and archives their media into the scope bucket
+func TelegramWebhook(ctx *fiber.Ctx) error {
+	scope := ctx.Params("scope", "")
+	if !utils.IsValidBucket(scope) {
+		return ctx.Status(404).JSON(models.GenericResponse{
+			Result:  false,
+			Message: "scope is not valid",
+		})
+	}
+
+	secret := getWebhookSecret(scope)
+	if secret == "" {
+		return ctx.Status(403).JSON(models.GenericResponse{
+			Result:  false,
+			Message: "webhook is not enabled for this scope",
+		})
+	}
+
+	provided := ctx.Get("X-Telegram-Bot-Api-Secret-Token")
+	if subtle.ConstantTimeCompare([]byte(provided), []byte(secret)) != 1 {
+		log.Printf("⚠️ Rejected webhook update for scope '%s': bad secret token", scope)
+		return ctx.Status(401).JSON(models.GenericResponse{
+			Result:  false,
+			Message: "invalid secret token",
+		})
+	}
+
+	var update telegram_api.Update
+	if err := json.Unmarshal(ctx.Body(), &update); err != nil {
+		return ctx.Status(400).JSON(models.GenericResponse{
+			Result:  false,
+			Message: err.Error(),
+		})
+	}
+
+	minioClient, err := getLocal[*config.MinIOClients](ctx, "minio")
+	if err != nil {
+		return err
+	}
+	botScopeConfig, err := getLocal[*config.BotScopeConfiguration](ctx, "BOT_SCOPE_CONFIG")
+	if err != nil {
+		return err
+	}
+	recordStore, err := getLocal[*record_store.Store](ctx, "RECORD_STORE")
+	if err != nil {
+		return err
+	}
+
+	// باتی که وبهوک مال اونه؛ file_id ها فقط برای همون بات تضمینی هستن
+	// Query به بافر درخواست اشاره می‌کنه و بعد از پاسخ عوض میشه، ولی archive تو goroutine اجرا میشه
+	receivingBot := strings.Clone(ctx.Query("bot", ""))
+	if _, found := findNamedBot(botScopeConfig.GetNamedBots(scope), receivingBot); receivingBot != "" && !found {
+		log.Printf("⚠️ Webhook for scope '%s' names unknown bot '%s', ignoring it", scope, receivingBot)
+		receivingBot = ""
+	}
+
+	queued, dropped := 0, 0
+	for _, message := range update.Messages() {
+		for _, file := range message.Files() {
+			record := WebhookFileRecord{
+				Scope:        scope,
+				ChatId:       message.Chat.Id,
+				ChatTitle:    message.Chat.Title,
+				MessageId:    message.MessageId,
+				MediaGroupId: message.MediaGroupId,
+				Kind:         file.Kind,
+				FileId:       file.FileId,
+				FileUniqueId: file.FileUniqueId,
+				FileName:     file.FileName,
+				MimeType:     file.MimeType,
+				FileSize:     file.FileSize,
+				Status:       "pending",
+				ReceivedAt:   time.Now(),
+			}
+
+			job := webhookArchiveJob{
+				minioClient:    minioClient,
+				botScopeConfig: botScopeConfig,
+				recordStore:    recordStore,
+				record:         record,
+				receivingBot:   receivingBot,
+			}
+			accepted := enqueueWebhookArchive(job)
+			if !accepted {
+				// صف پره؛ رکورد failed می‌مونه تا تو لیست دیده بشه
+				log.Printf("⚠️ Webhook archive queue is full, dropping file %s from chat %d", record.FileId, record.ChatId)
+				record.Status = "failed"
+				record.Error = "archive queue is full"
+			}
+
+			putCtx, cancelPut := context.WithTimeout(ctx.UserContext(), 5*time.Second)
+			if err := recordStore.Put(putCtx, record.key(), record); err != nil {
+				log.Printf("⚠️ Failed to record webhook file %s: %v", record.FileId, err)
+			}
+			cancelPut()
+
+			if accepted {
+				queued++
+			} else {
+				dropped++
+			}
+		}
+	}
+
+	log.Printf("📨 Webhook update %d for scope '%s': %d file(s) queued, %d dropped", update.UpdateId, scope, queued, dropped)
+
+	// تلگرام روی هر جواب غیر 2xx دوباره تلاش می‌کنه، پس همیشه سریع 200 برگردون
+	return ctx.Status(200).JSON(fiber.Map{
+		"result":  true,
+		"queued":  queued,
+		"dropped": dropped,
+	})
+}
+
+// archiveWebhookFile downloads a webhook file through the /instant download path and records the outcome
+func archiveWebhookFile(minioClient *config.MinIOClients, botScopeConfig *config.BotScopeConfiguration, recordStore *record_store.Store, record WebhookFileRecord, receivingBot string) {
+	// file_id های آپدیت مال باتی هستن که وبهوک رو گرفته
+	recordFileAffinity(recordStore, record.Scope, receivingBot, "webhook", telegram_api.UploadResult{
+		FileId:       record.FileId,
+		FileUniqueId: record.FileUniqueId,
+	})
+
+	cache := newTelegramCache(minioClient, record.Scope)
+
+	// file_unique_id برای همه بات‌ها یکسانه، پس اول با اون کش رو چک کن
+	key, found := cache.findObject(context.Background(), record.FileUniqueId)
+	if !found {
+		key, found = cache.lookup(context.Background(), record.FileId)
+	}
+
+	if found {
+		log.Printf("✅ Webhook file %s already cached as %s", record.FileId, key)
+		_ = cache.writeAlias(context.Background(), record.FileId, key)
+		record.ObjectKey = key
+		record.ArchivedBy = "cache"
+	} else {
+		namedBots := botScopeConfig.GetNamedBots(record.Scope)
+
+		// اول با باتی که آپدیت رو گرفته، بعد racing بین بقیه
+		_, fileData, resContentType, usedBotName, err := fetchFromTelegram(context.Background(), namedBots, record.FileId, "", receivingBot, getScopeStrategy(record.Scope, ""))
+
+		if err == nil {
+			if resContentType == "" || strings.Contains(resContentType, "octet-stream") {
+				resContentType = record.MimeType
+			}
+			extension := determineFileExtension(fileData, resContentType, record.FileId)
+			mimeType := getContentTypeFromExtension(extension)
+			record.ObjectKey, err = cache.store(record.FileId, record.FileUniqueId, extension, mimeType, fileData)
+			record.ArchivedBy = usedBotName
+		}
+
+		if err != nil {
+			log.Printf("❌ Failed to archive webhook file %s: %v", record.FileId, err)
+			record.Status = "failed"
+			record.Error = err.Error()
+			record.ObjectKey = ""
+		}
+	}
+
+	if record.Status != "failed" {
+		archivedAt := time.Now()
+		record.Status = "archived"
+		record.ArchivedAt = &archivedAt
+	}
+
+	putCtx, cancelPut := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelPut()
+	if err := recordStore.Put(putCtx, record.key(), record); err != nil {
+		log.Printf("⚠️ Failed to update webhook record %s: %v", record.FileId, err)
+	}
+}
+
+// ListWebhookRecords returns archived message→file mappings for a scope
+// Optional filters: chatId, messageId (requires chatId), limit (default 100, max 500)
+func ListWebhookRecords(ctx *fiber.Ctx) error {
+	scope := ctx.Params("scope", "")
+	if !utils.IsValidBucket(scope) {
+		return ctx.Status(404).JSON(models.GenericResponse{
+			Result:  false,
+			Message: "scope is not valid",
+		})
+	}
+
+	prefix := webhookPrefix + scope + "/"
+	if chatId := ctx.Query("chatId", ""); chatId != "" {
+		if _, err := strconv.ParseInt(chatId, 10, 64); err != nil {
+			return ctx.Status(400).JSON(models.GenericResponse{
+				Result:  false,
+				Message: "chatId must be numeric",
+			})
+		}
+		prefix += chatId + "/"
+
+		if messageId := ctx.Query("messageId", ""); messageId != "" {
+			if _, err := strconv.ParseInt(messageId, 10, 64); err != nil {
+				return ctx.Status(400).JSON(models.GenericResponse{
+					Result:  false,
+					Message: "messageId must be numeric",
+				})
+			}
+			prefix += messageId + "/"
+		}
+	}
+
+	limit := ctx.QueryInt("limit", 100)
+	if limit <= 0 {
+		limit = 100
+	} else if limit > 500 {
+		limit = 500
+	}
+
+	recordStore, err := getLocal[*record_store.Store](ctx, "RECORD_STORE")
+	if err != nil {
+		return err
+	}
+
+	keys, err := recordStore.List(ctx.UserContext(), prefix)
+	if err != nil {
+		return ctx.Status(500).JSON(models.GenericResponse{
+			Result:  false,
+			Message: err.Error(),
+		})
+	}
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	records := make([]WebhookFileRecord, 0, len(keys))
+	for _, key := range keys {
+		var record WebhookFileRecord
+		if err := recordStore.Get(ctx.UserContext(), key, &record); err != nil {
+			log.Printf("⚠️ Failed to read webhook record %s: %v", key, err)
+			continue
+		}
+		records = append(records, record)
+	}
+
+	return ctx.Status(200).JSON(fiber.Map{
+		"result":  true,
+		"count":   len(records),
+		"records": records,
+	})
+}
@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Archive formats of the multi-file endpoints
+const (
+	formatZip    = "zip"
+	formatTar    = "tar"
+	formatTarGz  = "tar.gz"
+	formatTarZst = "tar.zst"
+)
+
+// archiveFormat is an output format of the multi-file endpoints
+type archiveFormat struct {
+	Name        string
+	Extension   string
+	ContentType string
+}
+
+var archiveFormats = map[string]archiveFormat{
+	formatZip:    {Name: formatZip, Extension: "zip", ContentType: "application/zip"},
+	formatTar:    {Name: formatTar, Extension: "tar", ContentType: "application/x-tar"},
+	formatTarGz:  {Name: formatTarGz, Extension: "tar.gz", ContentType: "application/gzip"},
+	formatTarZst: {Name: formatTarZst, Extension: "tar.zst", ContentType: "application/zstd"},
+}
+
+// parseArchiveFormat reads ?format=zip|tar|tar.gz|tar.zst (default zip)
+func parseArchiveFormat(ctx *fiber.Ctx) (archiveFormat, error) {
+	format, ok := archiveFormats[ctx.Query("format", formatZip)]
+	if !ok {
+		return archiveFormat{}, fiber.NewError(400, fmt.Sprintf("unknown format '%s', expected %s, %s, %s or %s",
+			ctx.Query("format"), formatZip, formatTar, formatTarGz, formatTarZst))
+	}
+	return format, nil
+}
+
+// fileName names an archive after its id
+func (f archiveFormat) fileName(id string) string {
+	return id + "." + f.Extension
+}
+
+// needsSize reports whether entries must know their size before their data is written
+func (f archiveFormat) needsSize() bool {
+	return f.Name == formatTar || f.Name == formatTarGz || f.Name == formatTarZst
+}
+
+// archiveId keeps the request hash for zip archives; other formats of the same
+// request get an id of their own so they are stored and deduplicated separately
+func (f archiveFormat) archiveId(requestHash string) string {
+	if f.Name == formatZip {
+		return requestHash
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(f.Name+":"+requestHash)))
+}
+
+// archiveWriter writes the entries of one archive format
+type archiveWriter interface {
+	// create starts an entry of size bytes; tar needs the size up front
+	create(name string, size int64, method uint16, modified time.Time) (io.Writer, error)
+	// setComment stores the summary where the format has room for it
+	setComment(comment string) error
+	close() error
+}
+
+// newWriter starts an archive on w. Tarballs are compressed as a whole: media is
+// mostly compressed already, so gzip runs at its fastest level and zstd at its default
+// (fast) level; zip compresses each entry with its own method instead, and encrypts
+// every entry with AES-256 when a password is given.
+func (f archiveFormat) newWriter(w io.Writer, password string) (archiveWriter, error) {
+	if password != "" && f.Name != formatZip {
+		return nil, fmt.Errorf("encryption is only supported for %s archives", formatZip)
+	}
+
+	switch f.Name {
+	case formatTar:
+		return &tarArchiveWriter{tw: tar.NewWriter(w)}, nil
+	case formatTarGz:
+		gz, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
+		if err != nil {
+			return nil, err
+		}
+		return &tarArchiveWriter{tw: tar.NewWriter(gz), compressor: gz}, nil
+	case formatTarZst:
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedDefault))
+		if err != nil {
+			return nil, err
+		}
+		return &tarArchiveWriter{tw: tar.NewWriter(zw), compressor: zw}, nil
+	default:
+		return &zipArchiveWriter{zw: zip.NewWriter(w), password: password}, nil
+	}
+}
+
+// storedContentTypes are already compressed; deflating them costs CPU and saves nothing
+var storedContentTypes = []string{
+	"image/jpeg", "image/png", "image/gif", "image/webp", "image/avif", "image/heic", "image/heif",
+	"video/", "audio/mpeg", "audio/mp4", "audio/aac", "audio/ogg", "audio/opus", "audio/webm", "audio/flac",
+	"application/zip", "application/gzip", "application/x-gzip", "application/zstd", "application/x-bzip2",
+	"application/x-xz", "application/x-7z-compressed", "application/x-rar-compressed", "application/vnd.rar",
+}
+
+// zipMethodFor stores already-compressed media and uses method for everything else
+func zipMethodFor(contentType string, method uint16) uint16 {
+	contentType, _, _ = strings.Cut(strings.ToLower(contentType), ";")
+	for _, stored := range storedContentTypes {
+		if strings.HasPrefix(contentType, stored) {
+			return zip.Store
+		}
+	}
+	return method
+}
+
+type zipArchiveWriter struct {
+	zw       *zip.Writer
+	password string
+	// encrypted is the open encrypted entry, finished before the next one starts
+	encrypted *zipAESEntry
+}
+
+func (a *zipArchiveWriter) create(name string, size int64, method uint16, modified time.Time) (io.Writer, error) {
+	if err := a.finishEncrypted(); err != nil {
+		return nil, err
+	}
+	if a.password != "" {
+		entry, err := createAESEntry(a.zw, a.password, name, method, modified)
+		if err != nil {
+			return nil, err
+		}
+		a.encrypted = entry
+		return entry, nil
+	}
+
+	return a.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   method,
+		Modified: modified,
+	})
+}
+
+func (a *zipArchiveWriter) finishEncrypted() error {
+	if a.encrypted == nil {
+		return nil
+	}
+	entry := a.encrypted
+	a.encrypted = nil
+	return entry.finish()
+}
+
+func (a *zipArchiveWriter) setComment(comment string) error {
+	return a.zw.SetComment(comment)
+}
+
+func (a *zipArchiveWriter) close() error {
+	if err := a.finishEncrypted(); err != nil {
+		return err
+	}
+	return a.zw.Close()
+}
+
+type tarArchiveWriter struct {
+	tw *tar.Writer
+	// compressor wraps the whole tar stream; nil for plain tar
+	compressor io.WriteCloser
+}
+
+func (a *tarArchiveWriter) create(name string, size int64, method uint16, modified time.Time) (io.Writer, error) {
+	err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  modified,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a.tw, nil
+}
+
+// setComment is a no-op: tar has no archive comment, the summary stays in manifest.json
+func (a *tarArchiveWriter) setComment(comment string) error {
+	return nil
+}
+
+func (a *tarArchiveWriter) close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	if a.compressor != nil {
+		return a.compressor.Close()
+	}
+	return nil
+}
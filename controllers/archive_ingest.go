@@ -0,0 +1,577 @@
+package controllers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"go-uploader/config"
+	"go-uploader/models"
+	"go-uploader/pkg/record_store"
+	"go-uploader/utils"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/minio/minio-go/v7"
+)
+
+// maxTelegramUploadSize is the largest file the Bot API accepts
+const maxTelegramUploadSize = 50 * 1024 * 1024
+
+// ingestRatioSlack lets small, highly compressible files through the ratio check
+const ingestRatioSlack = 1024 * 1024
+
+// Outcomes of an ingested entry
+const (
+	ingestStored   = "stored"
+	ingestUploaded = "uploaded"
+	ingestSkipped  = "skipped"
+	ingestRejected = "rejected"
+	ingestFailed   = "failed"
+)
+
+// errIngestLimit is wrapped by the errors that stop an ingestion (possible archive bomb)
+var errIngestLimit = errors.New("archive exceeds the ingestion limits")
+
+// IngestEntry is the outcome of one file of an ingested archive
+type IngestEntry struct {
+	Name         string       `json:"name"`
+	Status       string       `json:"status"`
+	Size         int64        `json:"size"`
+	ContentType  string       `json:"contentType,omitempty"`
+	Key          string       `json:"key,omitempty"`
+	FileId       string       `json:"fileId,omitempty"`
+	FileUniqueId string       `json:"fileUniqueId,omitempty"`
+	MessageId    int64        `json:"messageId,omitempty"`
+	UploadedBy   string       `json:"uploadedBy,omitempty"`
+	Copies       []UploadCopy `json:"copies,omitempty"`
+	Error        string       `json:"error,omitempty"`
+}
+
+// ingestLimits guard against archive bombs
+type ingestLimits struct {
+	MaxEntries int
+	// MaxBytes bounds the uncompressed size of the whole archive
+	MaxBytes int64
+	// MaxRatio bounds uncompressed/compressed size
+	MaxRatio int64
+}
+
+// getIngestLimits reads INGEST_MAX_ENTRIES (default 1000), INGEST_MAX_BYTES (default 2GiB)
+// and INGEST_MAX_RATIO (default 100)
+func getIngestLimits() ingestLimits {
+	limits := ingestLimits{MaxEntries: 1000, MaxBytes: 2 * 1024 * 1024 * 1024, MaxRatio: 100}
+	if v := os.Getenv("INGEST_MAX_ENTRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			limits.MaxEntries = n
+		} else {
+			log.Printf("⚠️ Invalid INGEST_MAX_ENTRIES '%s', using 1000", v)
+		}
+	}
+	if v := os.Getenv("INGEST_MAX_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			limits.MaxBytes = n
+		} else {
+			log.Printf("⚠️ Invalid INGEST_MAX_BYTES '%s', using 2147483648", v)
+		}
+	}
+	if v := os.Getenv("INGEST_MAX_RATIO"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			limits.MaxRatio = n
+		} else {
+			log.Printf("⚠️ Invalid INGEST_MAX_RATIO '%s', using 100", v)
+		}
+	}
+	return limits
+}
+
+// ingestBudget counts what has been read from an archive against its limits
+type ingestBudget struct {
+	limits  ingestLimits
+	entries int
+	bytes   int64
+	// compressed counts the bytes read from a compressed tarball (nil otherwise)
+	compressed *countingReader
+	// exceeded is the first limit that was hit; the ingestion stops there
+	exceeded error
+}
+
+func (b *ingestBudget) addEntry() error {
+	b.entries++
+	if b.entries > b.limits.MaxEntries {
+		b.exceeded = fmt.Errorf("%w: more than %d entries", errIngestLimit, b.limits.MaxEntries)
+	}
+	return b.exceeded
+}
+
+// reader charges what is read from an entry to the budget
+func (b *ingestBudget) reader(r io.Reader) io.Reader {
+	return &budgetReader{r: r, budget: b}
+}
+
+type budgetReader struct {
+	r      io.Reader
+	budget *ingestBudget
+}
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	b := r.budget
+	if b.exceeded != nil {
+		return 0, b.exceeded
+	}
+
+	n, err := r.r.Read(p)
+	b.bytes += int64(n)
+	if b.bytes > b.limits.MaxBytes {
+		b.exceeded = fmt.Errorf("%w: more than %d bytes uncompressed", errIngestLimit, b.limits.MaxBytes)
+	} else if b.compressed != nil && b.bytes > b.limits.MaxRatio*b.compressed.n+ingestRatioSlack {
+		b.exceeded = fmt.Errorf("%w: compression ratio above %d:1", errIngestLimit, b.limits.MaxRatio)
+	}
+	if b.exceeded != nil {
+		return n, b.exceeded
+	}
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ingestItem is one entry of an uploaded archive
+type ingestItem struct {
+	name      string
+	regular   bool
+	encrypted bool
+	size      int64
+	open      func() (io.ReadCloser, error)
+}
+
+// detectArchiveFormat recognises an upload by its first bytes
+func detectArchiveFormat(head []byte) (string, bool) {
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return formatZip, true
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return formatTarGz, true
+	case bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return formatTarZst, true
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return formatTar, true
+	}
+	return "", false
+}
+
+// walkZip checks the whole central directory before anything is stored, so a zip
+// bomb is refused up front; archive/zip also fails entries longer than they claim
+func walkZip(src io.ReaderAt, size int64, budget *ingestBudget, fn func(ingestItem)) error {
+	zr, err := zip.NewReader(src, size)
+	if err != nil {
+		return fiber.NewError(400, fmt.Sprintf("invalid zip archive: %v", err))
+	}
+
+	limits := budget.limits
+	if len(zr.File) > limits.MaxEntries {
+		return fmt.Errorf("%w: %d entries, at most %d allowed", errIngestLimit, len(zr.File), limits.MaxEntries)
+	}
+	var total uint64
+	for _, f := range zr.File {
+		total += f.UncompressedSize64
+		if f.UncompressedSize64 > uint64(limits.MaxRatio)*f.CompressedSize64+ingestRatioSlack {
+			return fmt.Errorf("%w: %s has a compression ratio above %d:1", errIngestLimit, f.Name, limits.MaxRatio)
+		}
+	}
+	if total > uint64(limits.MaxBytes) {
+		return fmt.Errorf("%w: %d bytes uncompressed, at most %d allowed", errIngestLimit, total, limits.MaxBytes)
+	}
+
+	for _, f := range zr.File {
+		if err := budget.addEntry(); err != nil {
+			return err
+		}
+		fn(ingestItem{
+			name:      f.Name,
+			regular:   f.Mode().IsRegular(),
+			encrypted: f.Flags&0x1 != 0,
+			size:      int64(f.UncompressedSize64),
+			open: func() (io.ReadCloser, error) {
+				rc, err := f.Open()
+				if err != nil {
+					return nil, err
+				}
+				return struct {
+					io.Reader
+					io.Closer
+				}{budget.reader(rc), rc}, nil
+			},
+		})
+		if budget.exceeded != nil {
+			return budget.exceeded
+		}
+	}
+	return nil
+}
+
+// walkTar streams a tarball; limits are enforced as its entries are read
+func walkTar(src io.Reader, format string, budget *ingestBudget, fn func(ingestItem)) error {
+	var r io.Reader = src
+	switch format {
+	case formatTarGz:
+		budget.compressed = &countingReader{r: src}
+		gz, err := gzip.NewReader(budget.compressed)
+		if err != nil {
+			return fiber.NewError(400, fmt.Sprintf("invalid gzip stream: %v", err))
+		}
+		defer gz.Close()
+		r = gz
+	case formatTarZst:
+		budget.compressed = &countingReader{r: src}
+		zr, err := zstd.NewReader(budget.compressed)
+		if err != nil {
+			return fiber.NewError(400, fmt.Sprintf("invalid zstd stream: %v", err))
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	// the whole decompressed stream is charged, headers and padding included
+	tr := tar.NewReader(budget.reader(r))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if budget.exceeded != nil {
+			return budget.exceeded
+		}
+		if err != nil {
+			return fiber.NewError(400, fmt.Sprintf("invalid tar archive: %v", err))
+		}
+		if err := budget.addEntry(); err != nil {
+			return err
+		}
+		if header.Size > budget.limits.MaxBytes-budget.bytes {
+			return fmt.Errorf("%w: %s is %d bytes", errIngestLimit, header.Name, header.Size)
+		}
+
+		fn(ingestItem{
+			name:    header.Name,
+			regular: header.FileInfo().Mode().IsRegular(),
+			size:    header.Size,
+			open:    func() (io.ReadCloser, error) { return io.NopCloser(tr), nil },
+		})
+		if budget.exceeded != nil {
+			return budget.exceeded
+		}
+	}
+}
+
+// cleanIngestPath turns an entry name into a safe relative path, or says why it is unsafe
+func cleanIngestPath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return "", errors.New("absolute path")
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", errors.New("path leaves the archive")
+		}
+	}
+
+	cleaned := path.Clean(name)
+	if cleaned == "." {
+		return "", errors.New("empty name")
+	}
+	return cleaned, nil
+}
+
+// ingestContentType sniffs the data and falls back to the extension for generic types
+func ingestContentType(name string, head []byte) string {
+	contentType := http.DetectContentType(head)
+	if strings.HasPrefix(contentType, "application/octet-stream") || strings.HasPrefix(contentType, "text/plain") {
+		if byExtension := mime.TypeByExtension(path.Ext(name)); byExtension != "" {
+			return byExtension
+		}
+	}
+	return contentType
+}
+
+// archiveIngest stores the entries of one archive in a bucket or uploads them to a scope
+type archiveIngest struct {
+	ctx         context.Context
+	minioClient *config.MinIOClients
+	recordStore *record_store.Store
+	budget      *ingestBudget
+
+	// bucket mode
+	bucket string
+	prefix string
+	// keys hands out object keys; entries that clean to the same path get " (n)" copies
+	keys zipEntryNames
+
+	// Telegram mode
+	scope            string
+	namedBots        []config.NamedBot
+	preferredBotName string
+	destChatId       string
+	backupChats      []string
+
+	entries []IngestEntry
+}
+
+func (in *archiveIngest) add(item ingestItem) {
+	if strings.HasSuffix(item.name, "/") && !item.regular {
+		return // directories are implied by the paths of their files
+	}
+
+	entry := IngestEntry{Name: item.name, Size: item.size}
+	defer func() { in.entries = append(in.entries, entry) }()
+
+	cleaned, err := cleanIngestPath(item.name)
+	switch {
+	case err != nil:
+		entry.Status, entry.Error = ingestRejected, err.Error()
+		return
+	case !item.regular:
+		entry.Status, entry.Error = ingestSkipped, "not a regular file"
+		return
+	case item.encrypted:
+		entry.Status, entry.Error = ingestRejected, "encrypted entries are not supported"
+		return
+	case strings.HasPrefix(cleaned, "__MACOSX/") || path.Base(cleaned) == ".DS_Store":
+		entry.Status, entry.Error = ingestSkipped, "macOS metadata"
+		return
+	}
+
+	body, err := item.open()
+	if err != nil {
+		entry.Status, entry.Error = ingestFailed, err.Error()
+		return
+	}
+	defer body.Close()
+
+	reader := bufio.NewReader(body)
+	head, _ := reader.Peek(512)
+	entry.ContentType = ingestContentType(cleaned, head)
+
+	if in.bucket != "" {
+		err = in.store(&entry, cleaned, reader, item.size)
+	} else {
+		err = in.upload(&entry, cleaned, reader, item.size)
+	}
+	if err != nil {
+		if in.budget.exceeded != nil {
+			err = in.budget.exceeded
+		}
+		entry.Status, entry.Error = ingestFailed, err.Error()
+		log.Printf("❌ Ingesting %s failed: %v", item.name, err)
+	}
+}
+
+// store streams an entry into the bucket under prefix/<path>; a path already written
+// by this archive gets a numbered key instead of overwriting it
+func (in *archiveIngest) store(entry *IngestEntry, cleaned string, reader io.Reader, size int64) error {
+	key := in.keys.reserve(path.Join(in.prefix, cleaned))
+	_, err := in.minioClient.Storage.Conn().PutObject(in.ctx, in.bucket, key, reader, size, minio.PutObjectOptions{
+		ContentType: entry.ContentType,
+	})
+	if err != nil {
+		return err
+	}
+
+	entry.Status = ingestStored
+	entry.Key = key
+	return nil
+}
+
+// upload sends an entry through the scope's bots like /upload/telegram does
+func (in *archiveIngest) upload(entry *IngestEntry, cleaned string, reader io.Reader, size int64) error {
+	if size > maxTelegramUploadSize {
+		return fmt.Errorf("%d bytes exceeds the Telegram upload limit of %d bytes", size, maxTelegramUploadSize)
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, maxTelegramUploadSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxTelegramUploadSize {
+		return fmt.Errorf("file exceeds the Telegram upload limit of %d bytes", maxTelegramUploadSize)
+	}
+
+	fileName := path.Base(cleaned)
+	upload, usedBotName, _, err := uploadFileRouted(in.ctx, in.scope, in.namedBots, in.preferredBotName, entry.ContentType, fileName, data, in.destChatId)
+	if err != nil {
+		return err
+	}
+
+	scheduleCarrierCleanup(in.recordStore, in.scope, usedBotName, *upload)
+	recordFileAffinity(in.recordStore, in.scope, usedBotName, "ingest", *upload)
+	entry.Copies = fanOutUpload(in.ctx, in.recordStore, in.scope, in.namedBots, in.backupChats, upload, usedBotName, entry.ContentType, fileName, data)
+
+	entry.Status = ingestUploaded
+	entry.FileId = upload.FileId
+	entry.FileUniqueId = upload.FileUniqueId
+	entry.MessageId = upload.MessageId
+	entry.UploadedBy = usedBotName
+	return nil
+}
+
+func formValue(form *multipart.Form, key string) string {
+	if values := form.Value[key]; len(values) > 0 {
+		return strings.TrimSpace(values[0])
+	}
+	return ""
+}
+
+// IngestArchive explodes an uploaded zip or tarball (.tar, .tar.gz, .tar.zst) into a bucket
+// (form field bucket, optional prefix) or into Telegram through a scope's bots (form field
+// scope, optional botName). It answers with the outcome of every entry.
+func IngestArchive(ctx *fiber.Ctx) error {
+	form, err := ctx.MultipartForm()
+	if err != nil {
+		return ctx.Status(400).JSON(models.GenericResponse{
+			Result:  false,
+			Message: err.Error(),
+		})
+	}
+
+	if len(form.File["file"]) == 0 {
+		return ctx.Status(400).JSON(models.GenericResponse{
+			Result:  false,
+			Message: "File not uploaded",
+		})
+	}
+	file := form.File["file"][0]
+
+	ingest := &archiveIngest{
+		ctx:     ctx.UserContext(),
+		bucket:  formValue(form, "bucket"),
+		scope:   formValue(form, "scope"),
+		budget:  &ingestBudget{limits: getIngestLimits()},
+		entries: []IngestEntry{},
+		keys:    zipEntryNames{},
+	}
+	ingest.recordStore, _ = getLocal[*record_store.Store](ctx, "RECORD_STORE")
+
+	if (ingest.bucket == "") == (ingest.scope == "") {
+		return ctx.Status(400).JSON(models.GenericResponse{
+			Result:  false,
+			Message: "set either bucket or scope",
+		})
+	}
+
+	target := ingest.bucket
+	if ingest.bucket != "" {
+		if !utils.IsValidBucket(ingest.bucket) {
+			return ctx.Status(400).JSON(models.GenericResponse{
+				Result:  false,
+				Message: "Bucket Not Found",
+			})
+		}
+		if ingest.prefix, err = cleanZipFolder(formValue(form, "prefix")); err != nil {
+			return ctx.Status(400).JSON(models.GenericResponse{
+				Result:  false,
+				Message: err.Error(),
+			})
+		}
+		if ingest.minioClient, err = getLocal[*config.MinIOClients](ctx, "minio"); err != nil {
+			return err
+		}
+	} else {
+		target = ingest.scope
+		if !utils.IsValidBucket(ingest.scope) {
+			return ctx.Status(400).JSON(models.GenericResponse{
+				Result:  false,
+				Message: "bot name is not valid",
+			})
+		}
+		botScopeConfig, err := getLocal[*config.BotScopeConfiguration](ctx, "BOT_SCOPE_CONFIG")
+		if err != nil {
+			return err
+		}
+		ingest.namedBots = botScopeConfig.GetNamedBots(ingest.scope)
+		logNamedBots(ingest.namedBots, ingest.scope)
+		ingest.preferredBotName = formValue(form, "botName")
+		ingest.destChatId = botScopeConfig.GetDestChatId(ingest.scope)
+		if ingest.destChatId == "" {
+			return noDestChatResponse(ctx, ingest.scope)
+		}
+		ingest.backupChats = fanOutChats(ctx, botScopeConfig, ingest.scope)
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return ctx.Status(500).JSON(models.GenericResponse{
+			Result:  false,
+			Message: err.Error(),
+		})
+	}
+	defer src.Close()
+
+	head := make([]byte, 512)
+	n, _ := src.ReadAt(head, 0)
+	format, ok := detectArchiveFormat(head[:n])
+	if !ok {
+		return ctx.Status(400).JSON(models.GenericResponse{
+			Result:  false,
+			Message: fmt.Sprintf("unsupported archive, expected %s, %s, %s or %s", formatZip, formatTar, formatTarGz, formatTarZst),
+		})
+	}
+
+	log.Printf("📦 Ingesting %s archive %s (%d bytes) into %s", format, file.Filename, file.Size, target)
+
+	if format == formatZip {
+		err = walkZip(src, file.Size, ingest.budget, ingest.add)
+	} else {
+		err = walkTar(src, format, ingest.budget, ingest.add)
+	}
+
+	counts := map[string]int{}
+	for _, entry := range ingest.entries {
+		counts[entry.Status]++
+	}
+	response := fiber.Map{
+		"result":  err == nil,
+		"format":  format,
+		"total":   len(ingest.entries),
+		"stored":  counts[ingestStored] + counts[ingestUploaded],
+		"skipped": counts[ingestSkipped] + counts[ingestRejected],
+		"failed":  counts[ingestFailed],
+		"entries": ingest.entries,
+	}
+
+	if err != nil {
+		var fiberErr *fiber.Error
+		status := 500
+		switch {
+		case errors.Is(err, errIngestLimit):
+			status = 413
+		case errors.As(err, &fiberErr):
+			status = fiberErr.Code
+		}
+		log.Printf("❌ Ingestion of %s stopped after %d entries: %v", file.Filename, len(ingest.entries), err)
+		response["message"] = err.Error()
+		return ctx.Status(status).JSON(response)
+	}
+
+	log.Printf("✅ Ingested %s: %d stored, %d skipped, %d failed", file.Filename, response["stored"], response["skipped"], response["failed"])
+	return ctx.Status(200).JSON(response)
+}
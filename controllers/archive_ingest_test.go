@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestCleanIngestPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"photo.jpg", "photo.jpg", false},
+		{"docs/a/readme.txt", "docs/a/readme.txt", false},
+		{"docs//a/./readme.txt", "docs/a/readme.txt", false},
+		{`img\pic.png`, "img/pic.png", false},
+		{"a/b/", "a/b", false},
+		{"../evil.txt", "", true},
+		{"a/../../evil.txt", "", true},
+		{`a\..\..\evil.txt`, "", true},
+		{"/etc/passwd", "", true},
+		{`\windows\system.ini`, "", true},
+		{"C:/boot.ini", "", true},
+		{`C:\boot.ini`, "", true},
+		{"", "", true},
+		{".", "", true},
+		{"./", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cleanIngestPath(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cleanIngestPath(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("cleanIngestPath(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+type testArchiveFile struct {
+	name string
+	data []byte
+	// store writes the entry uncompressed (zip only)
+	store bool
+}
+
+func buildTestZip(t *testing.T, files []testArchiveFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		method := zip.Deflate
+		if f.store {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func buildTestTar(t *testing.T, files []testArchiveFile, gzipped bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if gzipped {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Size: int64(len(f.data)), Mode: 0o644, Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// readAll reads every entry like the ingestion does and returns the names seen
+func readAll(t *testing.T, names *[]string) func(item ingestItem) {
+	return func(item ingestItem) {
+		*names = append(*names, item.name)
+		body, err := item.open()
+		if err != nil {
+			t.Fatalf("open %s: %v", item.name, err)
+		}
+		defer body.Close()
+		_, _ = io.Copy(io.Discard, body)
+	}
+}
+
+func TestWalkZipLimits(t *testing.T) {
+	small := []testArchiveFile{
+		{name: "a.txt", data: []byte("hello")},
+		{name: "b/c.txt", data: []byte("world")},
+		{name: "d.bin", data: bytes.Repeat([]byte{1, 2, 3}, 1000), store: true},
+	}
+	limits := ingestLimits{MaxEntries: 10, MaxBytes: 1 << 20, MaxRatio: 100}
+
+	tests := []struct {
+		name      string
+		archive   []testArchiveFile
+		limits    ingestLimits
+		wantLimit bool
+		wantNames int
+	}{
+		{"within the limits", small, limits, false, 3},
+		{"too many entries", small, ingestLimits{MaxEntries: 2, MaxBytes: 1 << 20, MaxRatio: 100}, true, 0},
+		{"too many bytes", small, ingestLimits{MaxEntries: 10, MaxBytes: 1000, MaxRatio: 100}, true, 0},
+		{"compression ratio", []testArchiveFile{{name: "zeros", data: make([]byte, 8<<20)}}, ingestLimits{MaxEntries: 10, MaxBytes: 1 << 30, MaxRatio: 100}, true, 0},
+		{"small compressible files pass the ratio check", []testArchiveFile{{name: "zeros", data: make([]byte, 512<<10)}}, limits, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildTestZip(t, tt.archive)
+			var names []string
+			err := walkZip(bytes.NewReader(data), int64(len(data)), &ingestBudget{limits: tt.limits}, readAll(t, &names))
+
+			if got := errors.Is(err, errIngestLimit); got != tt.wantLimit {
+				t.Fatalf("walkZip error = %v, want limit error %v", err, tt.wantLimit)
+			}
+			if !tt.wantLimit && err != nil {
+				t.Fatalf("walkZip error = %v", err)
+			}
+			// a zip over the limits is refused before any entry is handed out
+			if len(names) != tt.wantNames {
+				t.Errorf("entries handed out = %v, want %d", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestWalkTarLimits(t *testing.T) {
+	small := []testArchiveFile{
+		{name: "a.txt", data: []byte("hello")},
+		{name: "b/c.txt", data: []byte("world")},
+		{name: "d.bin", data: bytes.Repeat([]byte{1, 2, 3}, 1000)},
+	}
+	limits := ingestLimits{MaxEntries: 10, MaxBytes: 1 << 20, MaxRatio: 100}
+
+	tests := []struct {
+		name      string
+		archive   []testArchiveFile
+		gzipped   bool
+		limits    ingestLimits
+		wantLimit bool
+		wantNames int
+	}{
+		{"plain tar within the limits", small, false, limits, false, 3},
+		{"tar.gz within the limits", small, true, limits, false, 3},
+		{"too many entries stops at the limit", small, false, ingestLimits{MaxEntries: 2, MaxBytes: 1 << 20, MaxRatio: 100}, true, 2},
+		{"entry larger than the remaining budget", small, false, ingestLimits{MaxEntries: 10, MaxBytes: 2000, MaxRatio: 100}, true, 2},
+		{"compression ratio", []testArchiveFile{{name: "ok.txt", data: []byte("hi")}, {name: "zeros", data: make([]byte, 16<<20)}}, true, ingestLimits{MaxEntries: 10, MaxBytes: 1 << 30, MaxRatio: 100}, true, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format := formatTar
+			if tt.gzipped {
+				format = formatTarGz
+			}
+			data := buildTestTar(t, tt.archive, tt.gzipped)
+			var names []string
+			err := walkTar(bytes.NewReader(data), format, &ingestBudget{limits: tt.limits}, readAll(t, &names))
+
+			if got := errors.Is(err, errIngestLimit); got != tt.wantLimit {
+				t.Fatalf("walkTar error = %v, want limit error %v", err, tt.wantLimit)
+			}
+			if !tt.wantLimit && err != nil {
+				t.Fatalf("walkTar error = %v", err)
+			}
+			if len(names) != tt.wantNames {
+				t.Errorf("entries handed out = %v, want %d", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestDetectArchiveFormat(t *testing.T) {
+	zipData := buildTestZip(t, []testArchiveFile{{name: "a", data: []byte("a")}})
+	tarData := buildTestTar(t, []testArchiveFile{{name: "a", data: []byte("a")}}, false)
+	gzData := buildTestTar(t, []testArchiveFile{{name: "a", data: []byte("a")}}, true)
+
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"zip", zipData, formatZip},
+		{"tar", tarData, formatTar},
+		{"tar.gz", gzData, formatTarGz},
+		{"tar.zst", []byte{0x28, 0xb5, 0x2f, 0xfd, 0, 0}, formatTarZst},
+		{"text", []byte("not an archive"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := detectArchiveFormat(tt.head); got != tt.want {
+				t.Errorf("detectArchiveFormat = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
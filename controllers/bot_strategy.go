@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"go-uploader/config"
+	"os"
+	"strings"
+	"time"
+)
+
+// Bot selection strategies for Telegram file operations
+const (
+	// strategyRace fires the best MAX_RACING_BOTS bots at once
+	strategyRace = "race"
+	// strategyHedge fires the best bot and adds the next one whenever the hedge delay passes without an answer
+	strategyHedge = "hedge"
+	// strategySingle uses only the best bot
+	strategySingle = "single"
+	// strategySpecific uses only the bot the caller asked for; not selectable by query
+	strategySpecific = "specific"
+)
+
+// isValidStrategy reports whether s can be requested with ?strategy=
+func isValidStrategy(s string) bool {
+	return s == strategyRace || s == strategyHedge || s == strategySingle
+}
+
+// getScopeStrategy returns the strategy for a scope: the requested one if given,
+// otherwise the scope's strategy (config file, then BOT_STRATEGY_<SCOPE>), the
+// global one (config file racing section, then BOT_STRATEGY), and finally race
+func getScopeStrategy(scope, requested string) string {
+	if requested != "" {
+		return requested
+	}
+	botScopeConfig := config.CurrentBotScopes()
+	candidates := []string{
+		botScopeConfig.GetScopeSettings(scope).Strategy,
+		os.Getenv("BOT_STRATEGY_" + strings.ToUpper(scope)),
+		botScopeConfig.Racing.Strategy,
+		os.Getenv("BOT_STRATEGY"),
+	}
+	for _, candidate := range candidates {
+		if v := strings.ToLower(candidate); isValidStrategy(v) {
+			return v
+		}
+	}
+	return strategyRace
+}
+
+// getHedgeDelay returns the configured hedge delay (config file, then BOT_HEDGE_DELAY),
+// or the p90 latency of the given bot (at least 100ms, 1s without samples)
+func getHedgeDelay(bot config.NamedBot) time.Duration {
+	for _, v := range []string{config.CurrentBotScopes().Racing.HedgeDelay, os.Getenv("BOT_HEDGE_DELAY")} {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+
+	delay := bot.P90Latency()
+	if delay == 0 {
+		return time.Second
+	}
+	if delay < 100*time.Millisecond {
+		return 100 * time.Millisecond
+	}
+	return delay
+}
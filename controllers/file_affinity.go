@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-uploader/pkg/record_store"
+	"go-uploader/pkg/telegram_api"
+	"log"
+	"time"
+)
+
+const affinityPrefix = "affinity/"
+
+// FileAffinityRecord remembers which bot of a scope produced a file_id
+type FileAffinityRecord struct {
+	Scope        string    `json:"scope"`
+	BotName      string    `json:"botName"`
+	FileId       string    `json:"fileId"`
+	FileUniqueId string    `json:"fileUniqueId,omitempty"`
+	Source       string    `json:"source"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+func affinityKey(scope, fileId string) string {
+	return fmt.Sprintf("%s%s/%s.json", affinityPrefix, scope, fileId)
+}
+
+// recordFileAffinity stores the owning bot of each uploaded file_id
+func recordFileAffinity(store *record_store.Store, scope, botName, source string, uploads ...telegram_api.UploadResult) {
+	if store == nil || botName == "" {
+		return
+	}
+
+	now := time.Now()
+	for _, upload := range uploads {
+		if upload.FileId == "" {
+			continue
+		}
+
+		record := FileAffinityRecord{
+			Scope:        scope,
+			BotName:      botName,
+			FileId:       upload.FileId,
+			FileUniqueId: upload.FileUniqueId,
+			Source:       source,
+			CreatedAt:    now,
+		}
+
+		putCtx, cancelPut := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := store.Put(putCtx, affinityKey(scope, upload.FileId), record); err != nil {
+			log.Printf("⚠️ Failed to record owner of FileID %s: %v", upload.FileId, err)
+		}
+		cancelPut()
+	}
+}
+
+// lookupFileAffinity returns the bot that produced fileId in a scope, if known
+func lookupFileAffinity(ctx context.Context, store *record_store.Store, scope, fileId string) (string, bool) {
+	if store == nil || fileId == "" {
+		return "", false
+	}
+
+	getCtx, cancelGet := context.WithTimeout(ctx, 3*time.Second)
+	defer cancelGet()
+
+	var record FileAffinityRecord
+	if err := store.Get(getCtx, affinityKey(scope, fileId), &record); err != nil {
+		if !errors.Is(err, record_store.ErrNotFound) {
+			log.Printf("⚠️ Failed to look up owner of FileID %s: %v", fileId, err)
+		}
+		return "", false
+	}
+
+	log.Printf("🧭 FileID %s is owned by bot '%s' in scope '%s'", fileId, record.BotName, scope)
+	return record.BotName, true
+}
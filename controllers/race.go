@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-uploader/config"
+	"log"
+	"strings"
+	"time"
+)
+
+// RaceOptions configures how Race spreads an operation over bots
+type RaceOptions struct {
+	// Operation names the operation in logs and errors, e.g. "GetFile"
+	Operation string
+	// MaxConcurrency is how many bots may run at once; 0 means all of them
+	MaxConcurrency int
+	// MaxAttempts is how many bots may be tried in total; 0 means all of them
+	MaxAttempts int
+	// AttemptTimeout bounds each bot's attempt; 0 means only Timeout applies
+	AttemptTimeout time.Duration
+	// Timeout bounds the whole race; 0 means only the parent context applies
+	Timeout time.Duration
+	// HedgeDelay, when set, starts bots one at a time: the next bot is added
+	// when this delay passes without an answer or a running attempt fails
+	HedgeDelay time.Duration
+	// ShouldContinue decides whether another bot is tried after a failure; nil means always
+	ShouldContinue func(err error) bool
+	// OnAttempt is called when a bot is started
+	OnAttempt func(bot config.NamedBot)
+	// OnResult is called when a bot finishes, possibly after Race returned
+	OnResult func(bot config.NamedBot, elapsed time.Duration, err error)
+}
+
+// RaceAttemptError is the failure of one bot in a race
+type RaceAttemptError struct {
+	Bot string
+	Err error
+}
+
+// RaceError aggregates why a race produced no result
+type RaceError struct {
+	Operation string
+	Attempts  []RaceAttemptError
+	// Skipped lists bots whose circuit breaker was open
+	Skipped []string
+	// Cause is set when the race itself was cancelled or timed out
+	Cause error
+}
+
+func (e *RaceError) Error() string {
+	var b strings.Builder
+	switch {
+	case e.Cause != nil:
+		fmt.Fprintf(&b, "%s: %v", e.Operation, e.Cause)
+	case e.Unavailable():
+		fmt.Fprintf(&b, "%s: all bots are unavailable (circuit open)", e.Operation)
+	case len(e.Attempts) == 0:
+		fmt.Fprintf(&b, "%s: no named bots available", e.Operation)
+	default:
+		fmt.Fprintf(&b, "%s: %d bot(s) failed", e.Operation, len(e.Attempts))
+	}
+	for _, attempt := range e.Attempts {
+		fmt.Fprintf(&b, "; %s: %v", attempt.Bot, attempt.Err)
+	}
+	return b.String()
+}
+
+// Unwrap exposes the cause and every bot's error to errors.Is and errors.As
+func (e *RaceError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Attempts)+1)
+	if e.Cause != nil {
+		errs = append(errs, e.Cause)
+	}
+	for _, attempt := range e.Attempts {
+		errs = append(errs, attempt.Err)
+	}
+	return errs
+}
+
+// Unavailable reports whether no bot was tried because every circuit breaker was open
+func (e *RaceError) Unavailable() bool {
+	return e.Cause == nil && len(e.Attempts) == 0 && len(e.Skipped) > 0
+}
+
+// Tried returns the names of the bots that were attempted and failed, in order
+func (e *RaceError) Tried() []string {
+	tried := make([]string, len(e.Attempts))
+	for i, attempt := range e.Attempts {
+		tried[i] = attempt.Bot
+	}
+	return tried
+}
+
+// isRaceUnavailable reports whether err is a race that found no usable bot
+func isRaceUnavailable(err error) bool {
+	var raceErr *RaceError
+	return errors.As(err, &raceErr) && raceErr.Unavailable()
+}
+
+// withOptionalTimeout derives a cancellable context that also expires after timeout, if it is set
+func withOptionalTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(parent, timeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// Race runs attempt on the given bots, in order, and returns the first success.
+// Bots whose circuit breaker is open are skipped, each attempt waits for a slot in
+// the bot's limiter (a busy bot fails the attempt so the next bot is started),
+// every attempt is recorded in the bot's statistics and breaker, and the remaining
+// attempts are cancelled through their context as soon as one bot wins.
+func Race[T any](parent context.Context, namedBots []config.NamedBot, opts RaceOptions, attempt func(ctx context.Context, bot config.NamedBot) (T, error)) (T, config.NamedBot, error) {
+	var zero T
+
+	operation := opts.Operation
+	if operation == "" {
+		operation = "request"
+	}
+	raceErr := &RaceError{Operation: operation}
+
+	if len(namedBots) == 0 {
+		return zero, config.NamedBot{}, raceErr
+	}
+
+	ctx, cancel := withOptionalTimeout(parent, opts.Timeout)
+	defer cancel()
+
+	maxConcurrency := opts.MaxConcurrency
+	if maxConcurrency <= 0 || maxConcurrency > len(namedBots) {
+		maxConcurrency = len(namedBots)
+	}
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 || maxAttempts > len(namedBots) {
+		maxAttempts = len(namedBots)
+	}
+
+	type raceResult struct {
+		value T
+		bot   config.NamedBot
+		err   error
+	}
+
+	// buffered so attempts that finish after the race is over never block
+	results := make(chan raceResult, len(namedBots))
+	next, running, launched := 0, 0, 0
+
+	// launch starts the next bot whose circuit breaker lets a request through
+	launch := func() bool {
+		for launched < maxAttempts && running < maxConcurrency && next < len(namedBots) {
+			bot := namedBots[next]
+			next++
+			if !bot.Breaker.Allow() {
+				raceErr.Skipped = append(raceErr.Skipped, bot.Name)
+				continue
+			}
+
+			running++
+			launched++
+			log.Printf("🚀 Bot '%s' attempting %s (%d/%d)", bot.Name, operation, launched, maxAttempts)
+			if opts.OnAttempt != nil {
+				opts.OnAttempt(bot)
+			}
+
+			go func() {
+				attemptCtx, attemptCancel := withOptionalTimeout(ctx, opts.AttemptTimeout)
+				defer attemptCancel()
+
+				// منتظر جای خالی روی این بات؛ اگه شلوغه خطا میده و بات بعدی شروع میشه
+				release, err := bot.Limiter.Acquire(attemptCtx)
+				if err != nil {
+					results <- raceResult{bot: bot, err: err}
+					return
+				}
+				defer release()
+
+				started := time.Now()
+				done := bot.Begin()
+				value, err := attempt(attemptCtx, bot)
+				done(err)
+				if opts.OnResult != nil {
+					opts.OnResult(bot, time.Since(started), err)
+				}
+				results <- raceResult{value: value, bot: bot, err: err}
+			}()
+			return true
+		}
+		return false
+	}
+
+	// launchAll fills every free slot, or one slot when hedging
+	launchAll := func() {
+		if opts.HedgeDelay > 0 {
+			launch()
+			return
+		}
+		for launch() {
+		}
+	}
+
+	launchAll()
+	if running == 0 {
+		log.Printf("💥 No bot could start %s: %v", operation, raceErr)
+		return zero, config.NamedBot{}, raceErr
+	}
+
+	var hedgeTimer <-chan time.Time
+	var timer *time.Timer
+	if opts.HedgeDelay > 0 {
+		timer = time.NewTimer(opts.HedgeDelay)
+		defer timer.Stop()
+		hedgeTimer = timer.C
+	}
+
+	for running > 0 {
+		select {
+		case result := <-results:
+			running--
+			if result.err == nil {
+				log.Printf("🏆 %s won by: '%s' (attempt %d/%d)", operation, result.bot.Name, launched, maxAttempts)
+				return result.value, result.bot, nil
+			}
+
+			log.Printf("❌ Bot '%s' failed %s: %v", result.bot.Name, operation, result.err)
+			raceErr.Attempts = append(raceErr.Attempts, RaceAttemptError{Bot: result.bot.Name, Err: result.err})
+
+			if opts.ShouldContinue != nil && !opts.ShouldContinue(result.err) {
+				log.Printf("🛑 %s: error is not retryable on another bot", operation)
+				return zero, config.NamedBot{}, raceErr
+			}
+
+			launchAll()
+			if timer != nil {
+				timer.Reset(opts.HedgeDelay)
+			}
+		case <-hedgeTimer:
+			if launch() {
+				log.Printf("⏱️ %s: no answer after %s, added another bot", operation, opts.HedgeDelay)
+				timer.Reset(opts.HedgeDelay)
+			}
+		case <-ctx.Done():
+			raceErr.Cause = ctx.Err()
+			log.Printf("⏱️ %s cancelled after %d attempt(s): %v", operation, launched, ctx.Err())
+			return zero, config.NamedBot{}, raceErr
+		}
+	}
+
+	log.Printf("💥 All %d attempts failed %s", launched, operation)
+	return zero, config.NamedBot{}, raceErr
+}
@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"go-uploader/config"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// raceBehavior is what a test bot does when it is attempted
+type raceBehavior func(ctx context.Context) (string, error)
+
+func succeedAfter(d time.Duration, value string) raceBehavior {
+	return func(ctx context.Context) (string, error) {
+		select {
+		case <-time.After(d):
+			return value, nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+func failAfter(d time.Duration, err error) raceBehavior {
+	return func(ctx context.Context) (string, error) {
+		select {
+		case <-time.After(d):
+			return "", err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// blockUntilCancelled never answers; it reports the cancellation of its attempt
+func blockUntilCancelled(cancelled chan<- string, name string) raceBehavior {
+	return func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		cancelled <- name
+		return "", ctx.Err()
+	}
+}
+
+func TestRace(t *testing.T) {
+	errA := errors.New("bot a failed")
+	errB := errors.New("bot b failed")
+	errC := errors.New("bot c failed")
+	errFatal := errors.New("not retryable")
+
+	tests := []struct {
+		name     string
+		bots     []string
+		behavior func(cancelled chan<- string) map[string]raceBehavior
+		opts     RaceOptions
+		// openBreakers opens the circuit of these bots before the race
+		openBreakers []string
+
+		wantValue     string
+		wantWinner    string
+		wantErrs      []error
+		wantTried     []string
+		wantCancelled []string
+		wantCause     error
+		wantUnavail   bool
+		maxRunning    int32
+	}{
+		{
+			name: "winner cancels the losers",
+			bots: []string{"a", "b", "c"},
+			behavior: func(cancelled chan<- string) map[string]raceBehavior {
+				return map[string]raceBehavior{
+					"a": blockUntilCancelled(cancelled, "a"),
+					"b": succeedAfter(10*time.Millisecond, "from b"),
+					"c": blockUntilCancelled(cancelled, "c"),
+				}
+			},
+			wantValue:     "from b",
+			wantWinner:    "b",
+			wantCancelled: []string{"a", "c"},
+		},
+		{
+			name: "max concurrency starts the next bot after a failure",
+			bots: []string{"a", "b", "c", "d"},
+			behavior: func(chan<- string) map[string]raceBehavior {
+				return map[string]raceBehavior{
+					"a": failAfter(10*time.Millisecond, errA),
+					"b": failAfter(20*time.Millisecond, errB),
+					"c": failAfter(10*time.Millisecond, errC),
+					"d": succeedAfter(10*time.Millisecond, "from d"),
+				}
+			},
+			opts:       RaceOptions{MaxConcurrency: 2},
+			wantValue:  "from d",
+			wantWinner: "d",
+			maxRunning: 2,
+		},
+		{
+			name: "every failure is aggregated",
+			bots: []string{"a", "b", "c"},
+			behavior: func(chan<- string) map[string]raceBehavior {
+				return map[string]raceBehavior{
+					"a": failAfter(0, errA),
+					"b": failAfter(10*time.Millisecond, errB),
+					"c": failAfter(20*time.Millisecond, errC),
+				}
+			},
+			opts:      RaceOptions{MaxConcurrency: 1},
+			wantErrs:  []error{errA, errB, errC},
+			wantTried: []string{"a", "b", "c"},
+		},
+		{
+			name: "max attempts limits the bots tried",
+			bots: []string{"a", "b", "c"},
+			behavior: func(chan<- string) map[string]raceBehavior {
+				return map[string]raceBehavior{
+					"a": failAfter(0, errA),
+					"b": failAfter(0, errB),
+					"c": succeedAfter(0, "from c"),
+				}
+			},
+			opts:      RaceOptions{MaxConcurrency: 1, MaxAttempts: 2},
+			wantErrs:  []error{errA, errB},
+			wantTried: []string{"a", "b"},
+		},
+		{
+			name: "a non-retryable error stops the race",
+			bots: []string{"a", "b"},
+			behavior: func(chan<- string) map[string]raceBehavior {
+				return map[string]raceBehavior{
+					"a": failAfter(0, errFatal),
+					"b": succeedAfter(0, "from b"),
+				}
+			},
+			opts: RaceOptions{
+				MaxConcurrency: 1,
+				ShouldContinue: func(err error) bool { return !errors.Is(err, errFatal) },
+			},
+			wantErrs:  []error{errFatal},
+			wantTried: []string{"a"},
+		},
+		{
+			name: "timeout cancels the race",
+			bots: []string{"a", "b"},
+			behavior: func(cancelled chan<- string) map[string]raceBehavior {
+				return map[string]raceBehavior{
+					"a": blockUntilCancelled(cancelled, "a"),
+					"b": blockUntilCancelled(cancelled, "b"),
+				}
+			},
+			opts:          RaceOptions{Timeout: 20 * time.Millisecond},
+			wantCause:     context.DeadlineExceeded,
+			wantCancelled: []string{"a", "b"},
+		},
+		{
+			name: "bots with an open circuit are skipped",
+			bots: []string{"a", "b"},
+			behavior: func(chan<- string) map[string]raceBehavior {
+				return map[string]raceBehavior{
+					"a": succeedAfter(0, "from a"),
+					"b": succeedAfter(0, "from b"),
+				}
+			},
+			openBreakers: []string{"a"},
+			wantValue:    "from b",
+			wantWinner:   "b",
+		},
+		{
+			name: "every circuit open",
+			bots: []string{"a", "b"},
+			behavior: func(chan<- string) map[string]raceBehavior {
+				return map[string]raceBehavior{
+					"a": succeedAfter(0, "from a"),
+					"b": succeedAfter(0, "from b"),
+				}
+			},
+			openBreakers: []string{"a", "b"},
+			wantUnavail:  true,
+		},
+	}
+
+	t.Setenv("BOT_BREAKER_FAILURES", "1")
+	t.Setenv("BOT_BREAKER_COOLDOWN", "1m")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cancelled := make(chan string, len(tt.bots))
+			behavior := tt.behavior(cancelled)
+
+			bots := make([]config.NamedBot, len(tt.bots))
+			for i, name := range tt.bots {
+				bots[i] = config.NamedBot{Name: name, Breaker: &config.CircuitBreaker{}}
+				for _, open := range tt.openBreakers {
+					if open == name {
+						bots[i].Breaker.Record(errors.New("down"))
+					}
+				}
+			}
+
+			var running, maxRunning atomic.Int32
+			value, winner, err := Race(context.Background(), bots, tt.opts, func(ctx context.Context, bot config.NamedBot) (string, error) {
+				n := running.Add(1)
+				defer running.Add(-1)
+				for {
+					seen := maxRunning.Load()
+					if n <= seen || maxRunning.CompareAndSwap(seen, n) {
+						break
+					}
+				}
+				return behavior[bot.Name](ctx)
+			})
+
+			if tt.wantWinner != "" {
+				if err != nil {
+					t.Fatalf("Race error = %v, want %q to win", err, tt.wantWinner)
+				}
+				if winner.Name != tt.wantWinner || value != tt.wantValue {
+					t.Fatalf("Race = %q by %q, want %q by %q", value, winner.Name, tt.wantValue, tt.wantWinner)
+				}
+			} else {
+				var raceErr *RaceError
+				if !errors.As(err, &raceErr) {
+					t.Fatalf("Race error = %v, want a *RaceError", err)
+				}
+				for _, want := range tt.wantErrs {
+					if !errors.Is(err, want) {
+						t.Errorf("Race error = %v, want it to wrap %v", err, want)
+					}
+				}
+				if tt.wantTried != nil && !slices.Equal(raceErr.Tried(), tt.wantTried) {
+					t.Errorf("Tried() = %v, want %v", raceErr.Tried(), tt.wantTried)
+				}
+				if tt.wantCause != nil && !errors.Is(raceErr.Cause, tt.wantCause) {
+					t.Errorf("Cause = %v, want %v", raceErr.Cause, tt.wantCause)
+				}
+				if raceErr.Unavailable() != tt.wantUnavail {
+					t.Errorf("Unavailable() = %v, want %v", raceErr.Unavailable(), tt.wantUnavail)
+				}
+			}
+
+			if tt.maxRunning > 0 && maxRunning.Load() > tt.maxRunning {
+				t.Errorf("%d bots ran at once, want at most %d", maxRunning.Load(), tt.maxRunning)
+			}
+
+			// losers are cancelled when Race returns, not when they give up on their own
+			got := map[string]bool{}
+			for range tt.wantCancelled {
+				select {
+				case name := <-cancelled:
+					got[name] = true
+				case <-time.After(time.Second):
+					t.Fatalf("attempts cancelled = %v, want %v", got, tt.wantCancelled)
+				}
+			}
+			for _, name := range tt.wantCancelled {
+				if !got[name] {
+					t.Errorf("bot %q was not cancelled", name)
+				}
+			}
+		})
+	}
+}
+
+func TestRaceParentCancelled(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	bots := []config.NamedBot{{Name: "a"}}
+	_, _, err := Race(parent, bots, RaceOptions{}, func(ctx context.Context, bot config.NamedBot) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	wg.Wait()
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Race error = %v, want the parent's cancellation", err)
+	}
+}
@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go-uploader/config"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// cacheAliasPrefix holds one small object per file_id pointing at the cached object key.
+// Telegram file ids never contain '/', so aliases can't collide with cached objects.
+const cacheAliasPrefix = "aliases/"
+
+// cacheStreamPartSize is the multipart part size of cache uploads, the S3 minimum
+const cacheStreamPartSize = 5 * 1024 * 1024
+
+// telegramCache is the MinIO cache of Telegram files for one scope bucket.
+// Objects are stored as <file_unique_id>.<ext> because file_unique_id is the same
+// for every bot, while each bot sees a different file_id for the same file.
+type telegramCache struct {
+	minioClient *config.MinIOClients
+	bucket      string
+}
+
+func newTelegramCache(minioClient *config.MinIOClients, bucket string) telegramCache {
+	return telegramCache{minioClient: minioClient, bucket: bucket}
+}
+
+// findObject returns the key of the cached object named <id>.<ext>
+func (c telegramCache) findObject(ctx context.Context, id string) (string, bool) {
+	if id == "" {
+		return "", false
+	}
+
+	listCtx, cancelList := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelList()
+
+	for info := range c.minioClient.Storage.Conn().ListObjects(listCtx, c.bucket, minio.ListObjectsOptions{
+		Prefix:    id,
+		Recursive: true,
+		UseV1:     true,
+	}) {
+		if info.Err != nil || info.Size == 0 {
+			continue
+		}
+		if strings.TrimSuffix(info.Key, filepath.Ext(info.Key)) == id {
+			return info.Key, true
+		}
+	}
+	return "", false
+}
+
+// resolveAlias returns the object key a file_id was aliased to, if it still exists
+func (c telegramCache) resolveAlias(ctx context.Context, fileId string) (string, bool) {
+	aliasCtx, cancelAlias := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelAlias()
+
+	object, err := c.minioClient.Storage.Conn().GetObject(aliasCtx, c.bucket, cacheAliasPrefix+fileId, minio.GetObjectOptions{})
+	if err != nil {
+		return "", false
+	}
+	defer object.Close()
+
+	target, err := io.ReadAll(io.LimitReader(object, 1024))
+	if err != nil || len(target) == 0 {
+		return "", false
+	}
+
+	key := string(target)
+	if _, err := c.minioClient.Storage.Conn().StatObject(aliasCtx, c.bucket, key, minio.StatObjectOptions{}); err != nil {
+		log.Printf("⚠️ Cache alias %s points at missing object %s", fileId, key)
+		return "", false
+	}
+	return key, true
+}
+
+// lookup finds a cached object for fileId via its alias, or a legacy <fileId>.<ext> object
+func (c telegramCache) lookup(ctx context.Context, fileId string) (string, bool) {
+	if key, ok := c.resolveAlias(ctx, fileId); ok {
+		return key, true
+	}
+	return c.findObject(ctx, fileId)
+}
+
+// writeAlias records that fileId is served by the cached object key
+func (c telegramCache) writeAlias(ctx context.Context, fileId, key string) error {
+	if fileId == "" || strings.TrimSuffix(key, filepath.Ext(key)) == fileId {
+		return nil
+	}
+
+	aliasCtx, cancelAlias := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelAlias()
+
+	_, err := c.minioClient.Storage.Conn().PutObject(aliasCtx, c.bucket, cacheAliasPrefix+fileId,
+		strings.NewReader(key), int64(len(key)), minio.PutObjectOptions{ContentType: "text/plain"})
+	if err != nil {
+		log.Printf("⚠️ Failed to write cache alias %s -> %s: %v", fileId, key, err)
+		return err
+	}
+	return nil
+}
+
+// store caches data under <fileUniqueId>.<extension> (or the file id when the
+// unique id is unknown) and aliases fileId to it. It returns the object key.
+func (c telegramCache) store(fileId, fileUniqueId, extension, mimeType string, fileData []byte) (string, error) {
+	uploadCtx, cancelUpload := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelUpload()
+
+	return c.put(uploadCtx, fileId, fileUniqueId, extension, mimeType, bytes.NewReader(fileData), int64(len(fileData)))
+}
+
+// put uploads size bytes of file (-1 when unknown) as the cached object and aliases fileId to it
+func (c telegramCache) put(ctx context.Context, fileId, fileUniqueId, extension, mimeType string, file io.Reader, size int64) (string, error) {
+	id := fileUniqueId
+	if id == "" {
+		id = fileId
+	}
+
+	fileName := id + "." + extension
+
+	log.Printf("📤 Uploading to MinIO cache: %s (size: %d bytes, type: %s)",
+		fileName, size, mimeType)
+
+	_, err := c.minioClient.Storage.Conn().PutObject(
+		ctx,
+		c.bucket,
+		fileName,
+		file,
+		size,
+		minio.PutObjectOptions{
+			ContentType: mimeType,
+			// بدون اندازه، minio-go بافر هر part رو برای حداکثر حجم (چند صد مگ) می‌گیره
+			PartSize: cacheStreamPartSize,
+		},
+	)
+
+	if err != nil {
+		log.Printf("❌ Failed to cache in MinIO: %v", err)
+		return "", err
+	}
+
+	log.Printf("✅ Successfully cached in MinIO: %s", fileName)
+	_ = c.writeAlias(ctx, fileId, fileName)
+	return fileName, nil
+}
+
+// storeWhileReading caches a download as it is read through the returned body.
+// The object is only stored when the body is read to the end; closing it earlier
+// aborts the upload. A failing upload never fails the reader.
+func (c telegramCache) storeWhileReading(fileId, fileUniqueId, extension, mimeType string, body io.ReadCloser, size int64) io.ReadCloser {
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		_, err := c.put(context.Background(), fileId, fileUniqueId, extension, mimeType, pipeReader, size)
+		// اگه آپلود زودتر fail بشه، نوشتن بعدی توی pipe نباید گیر کنه
+		_ = pipeReader.CloseWithError(err)
+	}()
+
+	tee := &cacheTee{pipe: pipeWriter}
+	return &cachingBody{Reader: io.TeeReader(body, tee), body: body, tee: tee}
+}
+
+// cacheTee feeds the cache upload and drops the data once the upload has failed
+type cacheTee struct {
+	pipe   *io.PipeWriter
+	failed bool
+}
+
+func (t *cacheTee) Write(p []byte) (int, error) {
+	if !t.failed {
+		if _, err := t.pipe.Write(p); err != nil {
+			t.failed = true
+		}
+	}
+	return len(p), nil
+}
+
+// errCacheAborted aborts the upload of a download that was not read to the end
+var errCacheAborted = errors.New("download closed before the end, not caching it")
+
+type cachingBody struct {
+	io.Reader
+	body io.ReadCloser
+	tee  *cacheTee
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	switch {
+	case err == io.EOF:
+		_ = b.tee.pipe.Close()
+	case err != nil:
+		// دانلود ناقص (مثلا بزرگ‌تر از سقف) نباید کش بشه
+		_ = b.tee.pipe.CloseWithError(err)
+	}
+	return n, err
+}
+
+func (b *cachingBody) Close() error {
+	// بعد از Close معمولی این خطا نادیده گرفته میشه
+	_ = b.tee.pipe.CloseWithError(errCacheAborted)
+	return b.body.Close()
+}
+
+// read returns the data and content type of a cached object
+func (c telegramCache) read(ctx context.Context, key string) ([]byte, string, error) {
+	getCtx, cancelGet := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelGet()
+
+	object, err := c.minioClient.Storage.Conn().GetObject(getCtx, c.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get cached object: %w", err)
+	}
+	defer object.Close()
+
+	// Get object info for content type
+	objInfo, statErr := object.Stat()
+	if statErr != nil {
+		log.Printf("⚠️ Failed to get object stat, continuing: %v", statErr)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(object, maxDownloadSize))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read cached object: %w", err)
+	}
+
+	return data, cachedContentType(key, objInfo), nil
+}
+
+// open streams a cached object; the caller closes it. It returns the size and content type.
+func (c telegramCache) open(ctx context.Context, key string) (io.ReadCloser, int64, string, error) {
+	object, err := c.minioClient.Storage.Conn().GetObject(ctx, c.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to get cached object: %w", err)
+	}
+
+	objInfo, err := object.Stat()
+	if err != nil {
+		_ = object.Close()
+		return nil, 0, "", fmt.Errorf("failed to stat cached object: %w", err)
+	}
+	return object, objInfo.Size, cachedContentType(key, objInfo), nil
+}
+
+// cachedContentType prefers the content type stored with an object over its extension
+func cachedContentType(key string, objInfo minio.ObjectInfo) string {
+	// Determine correct content type
+	contentType := getContentTypeFromExtension(strings.TrimPrefix(filepath.Ext(key), "."))
+
+	// If we have metadata from MinIO, use it
+	if objInfo.ContentType != "" && !strings.Contains(objInfo.ContentType, "octet-stream") {
+		contentType = objInfo.ContentType
+	}
+
+	return contentType
+}
@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"go-uploader/config"
+	"go-uploader/pkg/telegram_api"
+	"io"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// resolveTelegramFile runs getFile for fileId with the given strategy, or
+// with preferredBotName only when one is given
+func resolveTelegramFile(ctx context.Context, namedBots []config.NamedBot, fileId, preferredBotName, strategy string) (*telegram_api.FileInfo, *telegram_api.TelegramAPI, string, error) {
+	if preferredBotName != "" {
+		info, selectedBot, err := getFileWithSpecificBot(ctx, namedBots, preferredBotName, fileId)
+		if err != nil {
+			log.Printf("❌ getFileWithSpecificBot failed: %v", err)
+			return nil, nil, "", raceFiberError(err, "Failed to download with specific bot")
+		}
+		return info, selectedBot.API, selectedBot.Name, nil
+	}
+
+	info, winningBot, err := raceGetFile(ctx, namedBots, fileId, strategy)
+	if err != nil {
+		log.Printf("❌ raceGetFile failed: %v", err)
+		return nil, nil, "", raceFiberError(err, "Failed to get file info from Telegram")
+	}
+
+	// Debug logging for file path
+	log.Printf("📁 Raw file path from Telegram: %s (unique: %s)", info.FilePath, info.FileUniqueId)
+	return info, winningBot.API, winningBot.Name, nil
+}
+
+// resolveOwnedTelegramFile asks the bot that owns fileId first and falls back to
+// resolveTelegramFile with the strategy when there is no owner or it fails
+func resolveOwnedTelegramFile(ctx context.Context, namedBots []config.NamedBot, fileId, ownerBotName, strategy string) (*telegram_api.FileInfo, *telegram_api.TelegramAPI, string, error) {
+	if owner, found := findNamedBot(namedBots, ownerBotName); found {
+		log.Printf("🧭 Asking owning bot '%s' for FileID: %s", owner.Name, fileId)
+
+		info, _, err := Race(ctx, []config.NamedBot{owner}, RaceOptions{
+			Operation:   "GetFile",
+			MaxAttempts: 1,
+			Timeout:     10 * time.Second,
+		}, func(ctx context.Context, bot config.NamedBot) (*telegram_api.FileInfo, error) {
+			return bot.API.GetFileInfo(ctx, fileId)
+		})
+		if err == nil {
+			return info, owner.API, owner.Name, nil
+		}
+		log.Printf("⚠️ Owning bot '%s' failed, falling back to %s mode: %v", owner.Name, strategy, err)
+	} else if ownerBotName != "" {
+		log.Printf("⚠️ Owning bot '%s' is not in this scope anymore", ownerBotName)
+	}
+
+	return resolveTelegramFile(ctx, namedBots, fileId, "", strategy)
+}
+
+// raceFiberError maps a race failure to a client-safe fiber error;
+// bot errors may carry URLs and stay in the logs only
+func raceFiberError(err error, message string) error {
+	if isRaceUnavailable(err) {
+		return fiber.NewError(503, "All bots are unavailable (circuit open)")
+	}
+	return fiber.NewError(500, message)
+}
+
+// downloadResolvedFile downloads a resolved file with the bot that resolved it.
+// A failed download falls back to the other bots with the race or hedge strategy.
+func downloadResolvedFile(ctx context.Context, namedBots []config.NamedBot, info *telegram_api.FileInfo, selectedBotApi *telegram_api.TelegramAPI, winningBotName, strategy string) ([]byte, string, string, error) {
+	filePathString := selectedBotApi.Explode(info.FilePath)
+	log.Printf("📁 After Explode: %s", filePathString)
+
+	// ⚡ مهم: اول با همون باتی که GetFile برنده شده دانلود کن
+	log.Printf("🎯 Using bot '%s' for download (no racing)", winningBotName)
+
+	winningBot, found := findNamedBot(namedBots, winningBotName)
+	if !found {
+		winningBot = config.NamedBot{Name: winningBotName, API: selectedBotApi}
+	}
+
+	// بدون timeout، فایل‌های بزرگ ممکنه طول بکشن
+	result, _, err := Race(ctx, []config.NamedBot{winningBot}, RaceOptions{
+		Operation:   "DownloadFile",
+		MaxAttempts: 1,
+	}, func(ctx context.Context, bot config.NamedBot) (downloadResult, error) {
+		data, contentType, err := bot.API.DownloadFileWithContext(ctx, filePathString)
+		return downloadResult{data: data, contentType: contentType}, err
+	})
+	fileData, resContentType := result.data, result.contentType
+	if err == nil {
+		log.Printf("✅ Bot '%s' successfully downloaded the file", winningBotName)
+		return fileData, resContentType, winningBotName, nil
+	}
+
+	log.Printf("❌ Bot '%s' failed to download: %v", winningBotName, err)
+
+	switch strategy {
+	case strategySpecific:
+		return nil, "", "", fiber.NewError(500, "Failed to download with specific bot")
+	case strategySingle:
+		return nil, "", "", fiber.NewError(500, "Failed to download from Telegram")
+	}
+
+	log.Printf("🔄 Falling back to %s mode for download...", strategy)
+
+	// فقط اگه بات برنده fail شد، با بقیه racing کن
+	otherBots := make([]config.NamedBot, 0, len(namedBots))
+	for _, namedBot := range namedBots {
+		if namedBot.Name != winningBotName {
+			otherBots = append(otherBots, namedBot)
+		}
+	}
+
+	fileData, resContentType, downloadBot, err := raceDownloadFile(ctx, otherBots, filePathString, strategy)
+	if err != nil {
+		log.Printf("❌ All download attempts failed")
+		return nil, "", "", fiber.NewError(500, "Failed to download from Telegram")
+	}
+
+	return fileData, resContentType, fmt.Sprintf("GetFile:%s|Download:%s", winningBotName, downloadBot.Name), nil
+}
+
+// resolveRequestedTelegramFile resolves fileId with a preferred bot alone, or asks the
+// owner bot first and lets the strategy take over if it fails. It returns the strategy
+// that applies to the download.
+func resolveRequestedTelegramFile(ctx context.Context, namedBots []config.NamedBot, fileId, preferredBotName, ownerBotName, strategy string) (*telegram_api.FileInfo, *telegram_api.TelegramAPI, string, string, error) {
+	var info *telegram_api.FileInfo
+	var selectedBotApi *telegram_api.TelegramAPI
+	var botName string
+	var err error
+
+	if preferredBotName != "" {
+		strategy = strategySpecific
+		info, selectedBotApi, botName, err = resolveTelegramFile(ctx, namedBots, fileId, preferredBotName, strategy)
+	} else {
+		info, selectedBotApi, botName, err = resolveOwnedTelegramFile(ctx, namedBots, fileId, ownerBotName, strategy)
+	}
+	return info, selectedBotApi, botName, strategy, err
+}
+
+// fetchFromTelegram resolves and downloads a file. It returns the getFile
+// result, the data, the content type Telegram reported and a description of
+// the bot(s) that served it. A preferred bot is used alone; an owner bot is
+// asked first and the strategy takes over if it fails.
+func fetchFromTelegram(ctx context.Context, namedBots []config.NamedBot, fileId, preferredBotName, ownerBotName, strategy string) (*telegram_api.FileInfo, []byte, string, string, error) {
+	info, selectedBotApi, botName, strategy, err := resolveRequestedTelegramFile(ctx, namedBots, fileId, preferredBotName, ownerBotName, strategy)
+	if err != nil {
+		return nil, nil, "", "", err
+	}
+
+	fileData, resContentType, usedBotName, err := downloadResolvedFile(ctx, namedBots, info, selectedBotApi, botName, strategy)
+	if err != nil {
+		return nil, nil, "", "", err
+	}
+
+	log.Printf("✅ Complete download chain for FileID: %s", fileId)
+	return info, fileData, resContentType, usedBotName, nil
+}
+
+// telegramStream is an open download; size is -1 when Telegram did not send it
+type telegramStream struct {
+	body        io.ReadCloser
+	contentType string
+	size        int64
+}
+
+// openResolvedFile is the streaming counterpart of downloadResolvedFile: it returns the
+// open body instead of the data. A body outlives the race that opened it, so after the
+// winning bot fails the other bots are tried one at a time instead of racing them.
+func openResolvedFile(ctx context.Context, namedBots []config.NamedBot, info *telegram_api.FileInfo, selectedBotApi *telegram_api.TelegramAPI, winningBotName, strategy string) (telegramStream, string, error) {
+	filePathString := selectedBotApi.Explode(info.FilePath)
+
+	winningBot, found := findNamedBot(namedBots, winningBotName)
+	if !found {
+		winningBot = config.NamedBot{Name: winningBotName, API: selectedBotApi}
+	}
+
+	stream, err := openWithBot(ctx, winningBot, filePathString)
+	if err == nil {
+		return stream, winningBotName, nil
+	}
+
+	log.Printf("❌ Bot '%s' failed to open the download: %v", winningBotName, err)
+
+	switch strategy {
+	case strategySpecific:
+		return telegramStream{}, "", fiber.NewError(500, "Failed to download with specific bot")
+	case strategySingle:
+		return telegramStream{}, "", fiber.NewError(500, "Failed to download from Telegram")
+	}
+
+	otherBots := make([]config.NamedBot, 0, len(namedBots))
+	for _, namedBot := range namedBots {
+		if namedBot.Name != winningBotName {
+			otherBots = append(otherBots, namedBot)
+		}
+	}
+
+	ranked, _ := strategyRaceOptions(otherBots, strategy, "DownloadFile", 2)
+	for _, bot := range ranked {
+		stream, err := openWithBot(ctx, bot, filePathString)
+		if err == nil {
+			return stream, fmt.Sprintf("GetFile:%s|Download:%s", winningBotName, bot.Name), nil
+		}
+		log.Printf("❌ Bot '%s' failed to open the download: %v", bot.Name, err)
+	}
+
+	log.Printf("❌ All download attempts failed")
+	return telegramStream{}, "", fiber.NewError(500, "Failed to download from Telegram")
+}
+
+// openWithBot opens a download through Race so the bot's health and circuit are tracked
+func openWithBot(ctx context.Context, bot config.NamedBot, filePathString string) (telegramStream, error) {
+	stream, _, err := Race(ctx, []config.NamedBot{bot}, RaceOptions{
+		Operation:   "DownloadFile",
+		MaxAttempts: 1,
+	}, func(_ context.Context, bot config.NamedBot) (telegramStream, error) {
+		// بدنه بعد از تموم شدن race خونده میشه، پس با context فراخواننده باز میشه نه context اون
+		body, contentType, size, err := bot.API.OpenFileWithContext(ctx, filePathString)
+		return telegramStream{body: body, contentType: contentType, size: size}, err
+	})
+	return stream, err
+}
@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"context"
+	"go-uploader/config"
+	"go-uploader/pkg/record_store"
+	"go-uploader/pkg/telegram_api"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Ways a copy reached a backup chat
+const (
+	copyByFileId = "fileId"
+	copyReupload = "reupload"
+)
+
+// UploadCopy is the outcome of sending an upload to one backup chat
+type UploadCopy struct {
+	ChatId       string `json:"chatId"`
+	FileId       string `json:"fileId,omitempty"`
+	FileUniqueId string `json:"fileUniqueId,omitempty"`
+	MessageId    int64  `json:"messageId,omitempty"`
+	UploadedBy   string `json:"uploadedBy,omitempty"`
+	Method       string `json:"method,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// AlbumCopy is the outcome of sending an album to one backup chat
+type AlbumCopy struct {
+	ChatId     string                      `json:"chatId"`
+	Items      []telegram_api.UploadResult `json:"items,omitempty"`
+	UploadedBy string                      `json:"uploadedBy,omitempty"`
+	Error      string                      `json:"error,omitempty"`
+}
+
+// fanOutChats returns the backup chats of a scope unless the request sent ?fanout=false
+func fanOutChats(ctx *fiber.Ctx, botScopeConfig *config.BotScopeConfiguration, scope string) []string {
+	if ctx.QueryBool("fanout", true) {
+		return botScopeConfig.GetBackupChatIds(scope)
+	}
+	return nil
+}
+
+// noDestChatResponse is returned when a scope has no destination chat configured
+func noDestChatResponse(ctx *fiber.Ctx, scope string) error {
+	log.Printf("❌ No destination chat configured for scope '%s'", scope)
+	return ctx.Status(500).JSON(fiber.Map{
+		"result":  false,
+		"message": "No destination chat configured for scope '" + scope + "'",
+	})
+}
+
+// copyUploadToChat posts an uploaded file to chatId. The owning bot sends it by file_id;
+// if that fails the data is uploaded again with the scope's routing and failover.
+func copyUploadToChat(ctx context.Context, scope string, namedBots []config.NamedBot, chatId string, upload *telegram_api.UploadResult, ownerBotName, contentType, filename string, data []byte) UploadCopy {
+	result := UploadCopy{ChatId: chatId}
+
+	if owner, ok := findNamedBot(namedBots, ownerBotName); ok {
+		opts := RaceOptions{Operation: "send to backup chat " + chatId, MaxAttempts: 1, Timeout: 30 * time.Second}
+		sent, _, err := Race(ctx, []config.NamedBot{owner}, opts, func(ctx context.Context, bot config.NamedBot) (*telegram_api.UploadResult, error) {
+			return bot.API.SendFileById(ctx, contentType, upload.FileId, chatId)
+		})
+		if err == nil {
+			result.FileId = sent.FileId
+			result.FileUniqueId = sent.FileUniqueId
+			result.MessageId = sent.MessageId
+			result.UploadedBy = ownerBotName
+			result.Method = copyByFileId
+			return result
+		}
+		log.Printf("⚠️ Sending FileID to backup chat %s failed, uploading again: %v", chatId, err)
+	}
+
+	// فایل رو دوباره آپلود کن، شاید با بات دیگه
+	sent, usedBotName, _, err := uploadFileRouted(ctx, scope, namedBots, "", contentType, filename, data, chatId)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.FileId = sent.FileId
+	result.FileUniqueId = sent.FileUniqueId
+	result.MessageId = sent.MessageId
+	result.UploadedBy = usedBotName
+	result.Method = copyReupload
+	return result
+}
+
+// fanOutUpload sends an uploaded file to every backup chat in parallel and records the
+// owner of each copy. Copies are kept: they are not scheduled for carrier cleanup.
+func fanOutUpload(ctx context.Context, store *record_store.Store, scope string, namedBots []config.NamedBot, chatIds []string, upload *telegram_api.UploadResult, ownerBotName, contentType, filename string, data []byte) []UploadCopy {
+	if len(chatIds) == 0 {
+		return nil
+	}
+	log.Printf("📡 Fanning out '%s' to %d backup chat(s) in scope '%s'", filename, len(chatIds), scope)
+
+	copies := make([]UploadCopy, len(chatIds))
+	var wg sync.WaitGroup
+	for i, chatId := range chatIds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			copies[i] = copyUploadToChat(ctx, scope, namedBots, chatId, upload, ownerBotName, contentType, filename, data)
+		}()
+	}
+	wg.Wait()
+
+	for _, c := range copies {
+		if c.Error != "" {
+			log.Printf("❌ Backup copy to chat %s failed: %s", c.ChatId, c.Error)
+			continue
+		}
+		recordFileAffinity(store, scope, c.UploadedBy, "backup", telegram_api.UploadResult{FileId: c.FileId, FileUniqueId: c.FileUniqueId})
+	}
+	return copies
+}
+
+// fanOutAlbum uploads an album again to every backup chat in parallel
+func fanOutAlbum(ctx context.Context, store *record_store.Store, scope string, namedBots []config.NamedBot, chatIds []string, items []telegram_api.MediaGroupItem) []AlbumCopy {
+	if len(chatIds) == 0 {
+		return nil
+	}
+	log.Printf("📡 Fanning out album of %d items to %d backup chat(s) in scope '%s'", len(items), len(chatIds), scope)
+
+	copies := make([]AlbumCopy, len(chatIds))
+	var wg sync.WaitGroup
+	for i, chatId := range chatIds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results, usedBotName, _, err := uploadMediaGroupRouted(ctx, scope, namedBots, "", items, chatId)
+			if err != nil {
+				log.Printf("❌ Backup album to chat %s failed: %v", chatId, err)
+				copies[i] = AlbumCopy{ChatId: chatId, Error: err.Error()}
+				return
+			}
+			copies[i] = AlbumCopy{ChatId: chatId, Items: results, UploadedBy: usedBotName}
+			recordFileAffinity(store, scope, usedBotName, "backup", results...)
+		}()
+	}
+	wg.Wait()
+	return copies
+}
+
+// withCopies adds the backup copies and every resulting file_id to an upload response
+func withCopies(response fiber.Map, upload *telegram_api.UploadResult, copies []UploadCopy) fiber.Map {
+	if len(copies) == 0 {
+		return response
+	}
+
+	fileIds := []string{upload.FileId}
+	for _, c := range copies {
+		if c.FileId != "" {
+			fileIds = append(fileIds, c.FileId)
+		}
+	}
+	response["copies"] = copies
+	response["fileIds"] = fileIds
+	return response
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"context"
+	"go-uploader/config"
+	"go-uploader/pkg/telegram_api"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFanOutErrorsHideToken(t *testing.T) {
+	const token = "123:SECRETTOKEN"
+
+	// refused fails before the request is sent, dropped after it
+	refused := httptest.NewServer(http.NotFoundHandler())
+	refusedURL := refused.URL
+	refused.Close()
+	dropped := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+			conn.Close()
+		}
+	}))
+	defer dropped.Close()
+
+	tests := []struct {
+		name    string
+		baseURL string
+	}{
+		{"connection refused", refusedURL},
+		{"connection dropped", dropped.URL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TELEGRAM_API_BASE_URL", tt.baseURL)
+			namedBots := []config.NamedBot{{Name: "relic", API: telegram_api.New(token)}}
+
+			upload := &telegram_api.UploadResult{FileId: "F1", FileUniqueId: "U1"}
+			copied := copyUploadToChat(context.Background(), "telegram", namedBots, "-100", upload, "relic", "text/plain", "a.txt", []byte("hi"))
+			if copied.Error == "" || strings.Contains(copied.Error, "SECRETTOKEN") {
+				t.Errorf("file copy error = %q, want a failure without the token", copied.Error)
+			}
+
+			item := telegram_api.MediaGroupItem{ContentType: "image/jpeg", FileName: "a.jpg", Data: []byte("x")}
+			albums := fanOutAlbum(context.Background(), nil, "telegram", namedBots, []string{"-100"}, []telegram_api.MediaGroupItem{item, item})
+			if len(albums) != 1 || albums[0].Error == "" || strings.Contains(albums[0].Error, "SECRETTOKEN") {
+				t.Errorf("album copies = %+v, want a failure without the token", albums)
+			}
+		})
+	}
+}
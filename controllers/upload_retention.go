@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"go-uploader/config"
+	"go-uploader/pkg/record_store"
+	"go-uploader/pkg/telegram_api"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const retentionPrefix = "retention/"
+
+// CarrierMessageRecord is a pending cleanup of a message posted to DEST_CHAT_ID
+type CarrierMessageRecord struct {
+	Scope       string    `json:"scope"`
+	BotName     string    `json:"botName"`
+	ChatId      string    `json:"chatId"`
+	MessageId   int64     `json:"messageId"`
+	FileId      string    `json:"fileId"`
+	CreatedAt   time.Time `json:"createdAt"`
+	DeleteAfter time.Time `json:"deleteAfter"`
+}
+
+// getUploadRetention reads UPLOAD_RETENTION (e.g. "72h"); zero disables cleanup
+func getUploadRetention() time.Duration {
+	v := os.Getenv("UPLOAD_RETENTION")
+	if v == "" {
+		return 0
+	}
+	retention, err := time.ParseDuration(v)
+	if err != nil || retention < 0 {
+		log.Printf("⚠️ Invalid UPLOAD_RETENTION '%s', carrier cleanup disabled", v)
+		return 0
+	}
+	return retention
+}
+
+// retentionKey sorts records by expiry so the janitor can stop at the first future one
+func retentionKey(record CarrierMessageRecord) string {
+	return fmt.Sprintf("%s%020d-%s-%d.json", retentionPrefix, record.DeleteAfter.Unix(), record.ChatId, record.MessageId)
+}
+
+// scheduleCarrierCleanup records uploaded messages for deletion once the retention period passes
+func scheduleCarrierCleanup(store *record_store.Store, scope, botName string, uploads ...telegram_api.UploadResult) {
+	retention := getUploadRetention()
+	if retention == 0 || store == nil {
+		return
+	}
+
+	now := time.Now()
+	for _, upload := range uploads {
+		if upload.MessageId == 0 {
+			continue
+		}
+
+		record := CarrierMessageRecord{
+			Scope:       scope,
+			BotName:     botName,
+			ChatId:      strconv.FormatInt(upload.ChatId, 10),
+			MessageId:   upload.MessageId,
+			FileId:      upload.FileId,
+			CreatedAt:   now,
+			DeleteAfter: now.Add(retention),
+		}
+
+		putCtx, cancelPut := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := store.Put(putCtx, retentionKey(record), record); err != nil {
+			log.Printf("⚠️ Failed to schedule cleanup for message %d: %v", upload.MessageId, err)
+		} else {
+			log.Printf("🗓️ Scheduled cleanup of message %d in chat %s at %s", record.MessageId, record.ChatId, record.DeleteAfter.Format(time.RFC3339))
+		}
+		cancelPut()
+	}
+}
+
+// isPermanentDeleteError reports errors after which retrying deleteMessage is pointless
+func isPermanentDeleteError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "message to delete not found") ||
+		strings.Contains(msg, "message can't be deleted") ||
+		strings.Contains(msg, "chat not found")
+}
+
+// sweepCarrierMessages deletes every carrier message whose retention period has passed
+func sweepCarrierMessages(store *record_store.Store) {
+	botScopeConfig := config.CurrentBotScopes()
+
+	listCtx, cancelList := context.WithTimeout(context.Background(), 30*time.Second)
+	keys, err := store.List(listCtx, retentionPrefix)
+	cancelList()
+	if err != nil {
+		log.Printf("⚠️ Carrier cleanup listing failed: %v", err)
+		return
+	}
+
+	now := time.Now()
+	deleted := 0
+	for _, key := range keys {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+
+		var record CarrierMessageRecord
+		if err := store.Get(ctx, key, &record); err != nil {
+			log.Printf("⚠️ Failed to read cleanup record %s: %v", key, err)
+			cancel()
+			continue
+		}
+
+		// کلیدها بر اساس زمان مرتب هستن، بقیه هنوز منقضی نشدن
+		if record.DeleteAfter.After(now) {
+			cancel()
+			break
+		}
+
+		// فقط بات صاحب پیام می‌تونه پاکش کنه
+		bot, found := findNamedBot(botScopeConfig.GetNamedBots(record.Scope), record.BotName)
+		if !found {
+			log.Printf("⚠️ Bot '%s' no longer in scope '%s', dropping cleanup of message %d", record.BotName, record.Scope, record.MessageId)
+			_ = store.Delete(ctx, key)
+			cancel()
+			continue
+		}
+
+		// circuit بازه: رکورد می‌مونه برای sweep بعدی
+		if !bot.Breaker.Allow() {
+			log.Printf("⏳ Bot '%s' circuit is open, keeping cleanup of message %d for the next sweep", bot.Name, record.MessageId)
+			cancel()
+			continue
+		}
+		done := bot.Begin()
+		err = bot.API.DeleteMessage(ctx, record.ChatId, record.MessageId)
+		done(err)
+		if err != nil && !isPermanentDeleteError(err) {
+			log.Printf("❌ Failed to delete carrier message %d: %v", record.MessageId, err)
+			cancel()
+			continue
+		}
+
+		if err := store.Delete(ctx, key); err != nil {
+			log.Printf("⚠️ %v", err)
+		}
+		deleted++
+		cancel()
+	}
+
+	if deleted > 0 {
+		log.Printf("🧹 Carrier cleanup removed %d messages", deleted)
+	}
+}
+
+// StartCarrierCleanup runs the carrier message janitor until ctx is cancelled
+func StartCarrierCleanup(ctx context.Context, store *record_store.Store) {
+	if getUploadRetention() == 0 {
+		return
+	}
+
+	interval := 5 * time.Minute
+	if v := os.Getenv("UPLOAD_RETENTION_SWEEP"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			interval = d
+		}
+	}
+
+	log.Printf("✅ Carrier cleanup enabled: retention=%s, sweep=%s", getUploadRetention(), interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				sweepCarrierMessages(store)
+			}
+		}
+	}()
+}
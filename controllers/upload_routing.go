@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"go-uploader/config"
+	"go-uploader/pkg/telegram_api"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Upload routing modes
+const (
+	routingRoundRobin    = "round-robin"
+	routingLeastInFlight = "least-in-flight"
+)
+
+var (
+	roundRobinMu       sync.Mutex
+	roundRobinCounters = map[string]int{}
+)
+
+// getUploadRouting reads the scope's routing (config file, then UPLOAD_ROUTING_<SCOPE>),
+// then the global one (config file racing section, then UPLOAD_ROUTING); default round-robin
+func getUploadRouting(scope string) string {
+	botScopeConfig := config.CurrentBotScopes()
+	candidates := []string{
+		botScopeConfig.GetScopeSettings(scope).UploadRouting,
+		os.Getenv("UPLOAD_ROUTING_" + strings.ToUpper(scope)),
+		botScopeConfig.Racing.UploadRouting,
+		os.Getenv("UPLOAD_ROUTING"),
+	}
+	for _, candidate := range candidates {
+		switch v := strings.ToLower(candidate); v {
+		case routingRoundRobin, routingLeastInFlight:
+			return v
+		}
+	}
+	return routingRoundRobin
+}
+
+// nextRoundRobin returns the rotation offset for the next upload in a scope
+func nextRoundRobin(scope string) int {
+	roundRobinMu.Lock()
+	defer roundRobinMu.Unlock()
+	offset := roundRobinCounters[scope]
+	roundRobinCounters[scope] = offset + 1
+	return offset
+}
+
+// orderUploadBots returns the order in which bots are tried for an upload
+func orderUploadBots(scope string, namedBots []config.NamedBot) []config.NamedBot {
+	ordered := make([]config.NamedBot, 0, len(namedBots))
+
+	switch getUploadRouting(scope) {
+	case routingLeastInFlight:
+		ordered = append(ordered, namedBots...)
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return ordered[i].InFlight() < ordered[j].InFlight()
+		})
+	default:
+		offset := nextRoundRobin(scope) % len(namedBots)
+		ordered = append(ordered, namedBots[offset:]...)
+		ordered = append(ordered, namedBots[:offset]...)
+	}
+
+	return ordered
+}
+
+// isRetryableUploadError reports whether another bot may succeed where this one failed
+// without posting the message twice. Errors about the file itself (bad request, too large)
+// would fail on every bot, and a timeout or dropped connection may come after Telegram
+// already posted the message, so only errors known to happen before the send fail over.
+func isRetryableUploadError(err error) bool {
+	if errors.Is(err, config.ErrBotBusy) || telegram_api.IsNotSent(err) {
+		return true
+	}
+
+	var apiErr *telegram_api.APIError
+	if !errors.As(err, &apiErr) {
+		// timeout یا قطع اتصال: معلوم نیست پیام ارسال شده یا نه
+		return false
+	}
+
+	switch {
+	case apiErr.IsUnauthorized(), apiErr.IsRateLimited():
+		return true
+	case apiErr.StatusCode == 403:
+		// این بات عضو چت مقصد نیست یا بلاک شده
+		return true
+	case apiErr.StatusCode >= 500:
+		return true
+	case apiErr.StatusCode == 400:
+		return strings.Contains(strings.ToLower(apiErr.Description), "chat not found")
+	default:
+		return false
+	}
+}
+
+// failoverNamedBots runs fn on one bot at a time until it succeeds or fails with a
+// non-retryable error. Never in parallel, so a message is never posted twice.
+// When preferredBotName is set only that bot is used. It returns the bots tried in order.
+func failoverNamedBots[T any](ctx context.Context, scope string, namedBots []config.NamedBot, preferredBotName, operation string, fn func(bot config.NamedBot) (T, error)) (T, string, []string, error) {
+	if len(namedBots) == 0 {
+		var zero T
+		return zero, "", []string{}, fiber.NewError(500, "No named bots available")
+	}
+
+	opts := RaceOptions{
+		Operation:      operation,
+		MaxConcurrency: 1,
+		ShouldContinue: isRetryableUploadError,
+	}
+
+	var candidates []config.NamedBot
+	if preferredBotName != "" {
+		// فقط بات درخواست شده (یا پیش‌فرض اگه circuit اون بازه)
+		candidates = specificBotOrder(namedBots, preferredBotName)
+		opts.MaxAttempts = 1
+	} else {
+		candidates = orderUploadBots(scope, namedBots)
+	}
+
+	// Race calls OnAttempt from this goroutine only, so no locking is needed
+	tried := []string{}
+	opts.OnAttempt = func(bot config.NamedBot) {
+		tried = append(tried, bot.Name)
+	}
+
+	value, bot, err := Race(ctx, candidates, opts, func(_ context.Context, bot config.NamedBot) (T, error) {
+		return fn(bot)
+	})
+	if err == nil {
+		return value, bot.Name, tried, nil
+	}
+
+	var raceErr *RaceError
+	switch {
+	case isRaceUnavailable(err):
+		return value, "", tried, fiber.NewError(503, "All bots are unavailable (circuit open)")
+	case preferredBotName != "":
+		return value, "", tried, fiber.NewError(500, "Failed to "+operation+" with specific bot")
+	case errors.As(err, &raceErr) && len(raceErr.Attempts) > 0 && !isRetryableUploadError(raceErr.Attempts[len(raceErr.Attempts)-1].Err):
+		last := raceErr.Attempts[len(raceErr.Attempts)-1]
+		lastErr := last.Err
+		var apiErr *telegram_api.APIError
+		if errors.As(lastErr, &apiErr) {
+			return value, "", tried, fiber.NewError(400, lastErr.Error())
+		}
+		log.Printf("❌ %s failed on '%s' and may already have been sent, not retrying: %v", operation, last.Bot, lastErr)
+		return value, "", tried, fiber.NewError(504, "Failed to "+operation+", not retried on another bot because it may already have been sent")
+	default:
+		return value, "", tried, fiber.NewError(500, "All bots failed to "+operation)
+	}
+}
+
+// failoverStatus returns the HTTP status carried by a failover error
+func failoverStatus(err error) int {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
+	}
+	return 500
+}
+
+// uploadFileRouted uploads a file with the scope's upload routing and failover
+func uploadFileRouted(ctx context.Context, scope string, namedBots []config.NamedBot, preferredBotName, contentType, filename string, data []byte, destChatId string) (*telegram_api.UploadResult, string, []string, error) {
+	log.Printf("📤 Uploading file '%s' (%d bytes) in scope '%s'", filename, len(data), scope)
+
+	return failoverNamedBots(ctx, scope, namedBots, preferredBotName, "upload file", func(bot config.NamedBot) (*telegram_api.UploadResult, error) {
+		return bot.API.UploadFile(contentType, filename, data, destChatId)
+	})
+}
+
+// uploadMediaGroupRouted uploads an album with the scope's upload routing and failover
+func uploadMediaGroupRouted(ctx context.Context, scope string, namedBots []config.NamedBot, preferredBotName string, items []telegram_api.MediaGroupItem, destChatId string) ([]telegram_api.UploadResult, string, []string, error) {
+	log.Printf("📤 Uploading album of %d items in scope '%s'", len(items), scope)
+
+	return failoverNamedBots(ctx, scope, namedBots, preferredBotName, "upload album", func(bot config.NamedBot) ([]telegram_api.UploadResult, error) {
+		return bot.API.SendMediaGroup(items, destChatId)
+	})
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-uploader/config"
+	"go-uploader/pkg/telegram_api"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestIsRetryableUploadError(t *testing.T) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Post", URL: "https://api.telegram.org/botTOKEN/sendDocument", Err: err}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection refused", urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), true},
+		{"dial timeout", urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: context.DeadlineExceeded}), true},
+		{"dns failure", urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "api.telegram.org"}}), true},
+		{"proxy connect", urlErr(&net.OpError{Op: "proxyconnect", Net: "tcp", Err: syscall.ECONNREFUSED}), true},
+		{"redacted not sent", fmt.Errorf("copyMessage request failed: dial tcp: refused: %w", telegram_api.ErrNotSent), true},
+		{"bot busy", config.ErrBotBusy, true},
+		{"unauthorized", &telegram_api.APIError{StatusCode: 401}, true},
+		{"forbidden", &telegram_api.APIError{StatusCode: 403}, true},
+		{"rate limited", &telegram_api.APIError{StatusCode: 429, RetryAfter: 3}, true},
+		{"server error", &telegram_api.APIError{StatusCode: 502}, true},
+		{"chat not found", &telegram_api.APIError{StatusCode: 400, Description: "Bad Request: chat not found"}, true},
+
+		{"bad request", &telegram_api.APIError{StatusCode: 400, Description: "Bad Request: file is too big"}, false},
+		{"response timeout", urlErr(context.DeadlineExceeded), false},
+		{"read timeout", urlErr(&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}), false},
+		{"connection reset", urlErr(&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}), false},
+		{"dropped response", urlErr(io.ErrUnexpectedEOF), false},
+		{"cancelled", fmt.Errorf("sendDocument request failed: %w", context.Canceled), false},
+		{"unknown", errors.New("something else"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableUploadError(tt.err); got != tt.want {
+				t.Errorf("isRetryableUploadError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	errWrongPassword = errors.New("wrong password")
+	errBadMAC        = errors.New("authentication code does not match")
+)
+
+// decryptAESEntry reads an AE-2 entry the way 7-Zip does: it checks the password
+// verifier and the HMAC, decrypts with a little-endian counter and inflates
+func decryptAESEntry(t *testing.T, f *zip.File, password string) ([]byte, error) {
+	t.Helper()
+	if f.Method != zipMethodAES || f.Flags&0x1 == 0 {
+		t.Fatalf("%s: method %d flags %#x, want an encrypted AES entry", f.Name, f.Method, f.Flags)
+	}
+	if len(f.Extra) < 11 || binary.LittleEndian.Uint16(f.Extra) != zipAESExtraID ||
+		binary.LittleEndian.Uint16(f.Extra[4:]) != 2 || string(f.Extra[6:8]) != "AE" || f.Extra[8] != 3 {
+		t.Fatalf("%s: extra field %x is not AE-2 with AES-256", f.Name, f.Extra)
+	}
+	if f.CRC32 != 0 {
+		t.Errorf("%s: CRC = %#x, AE-2 leaves it at zero", f.Name, f.CRC32)
+	}
+	method := binary.LittleEndian.Uint16(f.Extra[9:])
+
+	raw, err := f.OpenRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < zipAESSaltSize+zipAESVerifySize+zipAESMACSize {
+		t.Fatalf("%s: %d bytes of raw data is too short", f.Name, len(data))
+	}
+
+	salt := data[:zipAESSaltSize]
+	verifier := data[zipAESSaltSize : zipAESSaltSize+zipAESVerifySize]
+	ciphertext := data[zipAESSaltSize+zipAESVerifySize : len(data)-zipAESMACSize]
+	mac := data[len(data)-zipAESMACSize:]
+
+	keys := pbkdf2.Key([]byte(password), salt, zipAESIterations, 2*zipAESKeySize+zipAESVerifySize, sha1.New)
+	if !bytes.Equal(keys[2*zipAESKeySize:], verifier) {
+		return nil, errWrongPassword
+	}
+
+	h := hmac.New(sha1.New, keys[zipAESKeySize:2*zipAESKeySize])
+	h.Write(ciphertext)
+	if !hmac.Equal(h.Sum(nil)[:zipAESMACSize], mac) {
+		return nil, errBadMAC
+	}
+
+	block, err := aes.NewCipher(keys[:zipAESKeySize])
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := make([]byte, len(ciphertext))
+	var counter, stream [aes.BlockSize]byte
+	for i := 0; i < len(ciphertext); i += aes.BlockSize {
+		binary.LittleEndian.PutUint64(counter[:], uint64(i/aes.BlockSize+1))
+		block.Encrypt(stream[:], counter[:])
+		for j := i; j < min(i+aes.BlockSize, len(ciphertext)); j++ {
+			plain[j] = ciphertext[j] ^ stream[j-i]
+		}
+	}
+
+	if method == zip.Deflate {
+		plain, err = io.ReadAll(flate.NewReader(bytes.NewReader(plain)))
+		if err != nil {
+			t.Fatalf("%s: inflate: %v", f.Name, err)
+		}
+	}
+	return plain, nil
+}
+
+func TestZipAESRoundTrip(t *testing.T) {
+	const password = "correct horse battery"
+	text := bytes.Repeat([]byte("encrypted zip entries round trip. "), 500)
+	random := make([]byte, 70000)
+	for i := range random {
+		random[i] = byte(i*7 + i/251)
+	}
+
+	tests := []struct {
+		name   string
+		method uint16
+		data   []byte
+	}{
+		{"empty.txt", zip.Deflate, nil},
+		{"one block.txt", zip.Store, []byte("sixteen bytes!!!")},
+		{"partial block.txt", zip.Store, []byte("not a multiple of the block size")},
+		{"deflated.txt", zip.Deflate, text},
+		{"stored.bin", zip.Store, random},
+		{"سند.txt", zip.Deflate, []byte("non-ASCII names set the UTF-8 flag")},
+	}
+
+	var buf bytes.Buffer
+	archive, err := archiveFormats[formatZip].newWriter(&buf, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		w, err := archive.create(tt.name, int64(len(tt.data)), tt.method, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// several writes exercise the counter across Write calls
+		for chunk := range slices.Chunk(tt.data, 1000) {
+			if _, err := w.Write(chunk); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := archive.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("archive is not a valid zip: %v", err)
+	}
+	if len(zr.File) != len(tests) {
+		t.Fatalf("archive has %d entries, want %d", len(zr.File), len(tests))
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := zr.File[i]
+			if f.Name != tt.name {
+				t.Fatalf("entry %d is %q, want %q", i, f.Name, tt.name)
+			}
+			if f.UncompressedSize64 != uint64(len(tt.data)) {
+				t.Errorf("uncompressed size = %d, want %d", f.UncompressedSize64, len(tt.data))
+			}
+			if utf8Flag := f.Flags&0x800 != 0; utf8Flag == isASCII(tt.name) {
+				t.Errorf("UTF-8 flag = %v for %q", utf8Flag, tt.name)
+			}
+
+			got, err := decryptAESEntry(t, f, password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Fatalf("decrypted %d bytes that differ from the %d written", len(got), len(tt.data))
+			}
+
+			// the 2-byte verifier lets one wrong password in 65536 through to the HMAC check
+			if _, err := decryptAESEntry(t, f, "wrong password!"); !errors.Is(err, errWrongPassword) && !errors.Is(err, errBadMAC) {
+				t.Errorf("wrong password error = %v, want it rejected", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,984 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"go-uploader/config"
+	"go-uploader/pkg/record_store"
+	"go-uploader/pkg/telegram_api"
+	"go-uploader/utils"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// Archive modes
+const (
+	// zipModeStrict aborts the archive on the first failed file
+	zipModeStrict = "strict"
+	// zipModeBestEffort skips failed files and appends manifest.json and errors.txt
+	zipModeBestEffort = "best-effort"
+)
+
+const maxZipFiles = 50
+
+// zipEntry is one requested file of an archive: a Telegram file (BotName, FileId),
+// a MinIO object (Bucket, Key) or an external link (URL)
+type zipEntry struct {
+	BotName string
+	FileId  string
+	Bucket  string
+	Key     string
+	URL     string
+	// Name is the requested file name without extension; defaults to the file id,
+	// the object name or the last segment of the link
+	Name string
+	// Folder is the directory of the entry inside the archive (optional)
+	Folder string
+	// Bot downloads the file with that bot of the scope instead of racing (optional)
+	Bot string
+}
+
+// ref identifies the entry in logs and errors.txt
+func (e zipEntry) ref() string {
+	switch {
+	case e.Bucket != "":
+		return e.Bucket + "/" + e.Key
+	case e.URL != "":
+		return e.URL
+	}
+	return e.FileId
+}
+
+// zipRequestItem is one file of an application/json zip request
+type zipRequestItem struct {
+	Scope  string `json:"scope"`
+	FileId string `json:"fileId"`
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	URL    string `json:"url"`
+	Name   string `json:"name"`
+	Folder string `json:"folder"`
+	Bot    string `json:"bot"`
+}
+
+// Where a zip entry was read from
+const (
+	zipSourceCache    = "cache"
+	zipSourceTelegram = "telegram"
+	zipSourceBucket   = "bucket"
+	zipSourceLink     = "link"
+)
+
+// zipFile is a downloaded entry, or the reason it could not be downloaded
+type zipFile struct {
+	index int
+	entry zipEntry
+	// body streams the file; the builder closes it
+	body io.ReadCloser
+	// size of body in bytes, needed by tar headers
+	size        int64
+	contentType string
+	extension   string
+	source      string
+	servedBy    string
+	// fetchTime is how long it took to resolve and open the file
+	fetchTime time.Duration
+	err       error
+}
+
+// close releases the body of an entry that is not written to the archive
+func (f zipFile) close() {
+	if f.body != nil {
+		_ = f.body.Close()
+	}
+}
+
+// zipBuildOptions tune how an archive is built
+type zipBuildOptions struct {
+	Mode string
+	// MaxConcurrent bounds parallel downloads; 0 downloads every file at once
+	MaxConcurrent int
+	// Attempts is how many times a failed download is tried
+	Attempts int
+	// Method is the zip compression method of the entries; already-compressed media is always stored
+	Method uint16
+	// Format is the archive format (zip by default)
+	Format archiveFormat
+	// Password encrypts every zip entry with WinZip AES-256 (optional)
+	Password string
+}
+
+// ZipManifestEntry is the outcome of one requested file
+type ZipManifestEntry struct {
+	BotName  string `json:"botName,omitempty"`
+	FileId   string `json:"fileId,omitempty"`
+	Bucket   string `json:"bucket,omitempty"`
+	Key      string `json:"key,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Name     string `json:"name"`
+	Folder   string `json:"folder,omitempty"`
+	Bot      string `json:"bot,omitempty"`
+	Entry    string `json:"entry,omitempty"`
+	Status   string `json:"status"`
+	Size     int64  `json:"size"`
+	Source   string `json:"source,omitempty"`
+	ServedBy string `json:"servedBy,omitempty"`
+	// DurationMs covers fetching the file and writing it into the archive
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ZipManifest describes what an archive contains and what was left out
+type ZipManifest struct {
+	Archive   string             `json:"archive"`
+	Mode      string             `json:"mode"`
+	Total     int                `json:"total"`
+	Succeeded int                `json:"succeeded"`
+	Failed    int                `json:"failed"`
+	Bytes     int64              `json:"bytes"`
+	CreatedAt time.Time          `json:"createdAt"`
+	Entries   []ZipManifestEntry `json:"entries"`
+}
+
+// summary is a one-line description of the manifest, used as the zip comment
+func (m *ZipManifest) summary() string {
+	return fmt.Sprintf("%d/%d files added, %d failed, %d bytes", m.Succeeded, m.Total, m.Failed, m.Bytes)
+}
+
+// parseZipRequest decodes the body of the zip endpoints, either a text/plain base64 JSON
+// [[botName, fileId], [botName, fileId, name], ...] or an application/json array of
+// {"scope", "fileId", "name", "folder", "bot"} objects, where {"bucket", "key"} or {"url"}
+// can take the place of scope and fileId.
+// It also returns the request hash, the sha256 of the body that names the archive.
+func parseZipRequest(ctx *fiber.Ctx) ([]zipEntry, string, error) {
+	var entries []zipEntry
+	var err error
+
+	body := ctx.Body()
+	contentType := ctx.Get("Content-Type")
+	switch {
+	case contentType == "text/plain":
+		entries, err = parseZipTextBody(body)
+	case strings.HasPrefix(contentType, fiber.MIMEApplicationJSON):
+		entries, err = parseZipJSONBody(body)
+	default:
+		return nil, "", fiber.NewError(400, fmt.Sprintf("Content-Type %s not supported", contentType))
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	requestHash := fmt.Sprintf("%x", sha256.Sum256(body))
+	return entries, requestHash, nil
+}
+
+// parseZipTextBody decodes the legacy base64 [][]string body
+func parseZipTextBody(bodyBase64 []byte) ([]zipEntry, error) {
+	bodyRaw, err := base64.StdEncoding.DecodeString(string(bodyBase64))
+	if err != nil {
+		return nil, fiber.NewError(500, err.Error())
+	}
+
+	var requestData [][]string
+	if err = json.Unmarshal(bodyRaw, &requestData); err != nil {
+		return nil, fiber.NewError(400, err.Error())
+	}
+
+	if len(requestData) > maxZipFiles {
+		return nil, fiber.NewError(400, fmt.Sprintf("too many files: %d exceeds maximum of %d", len(requestData), maxZipFiles))
+	}
+
+	// حداقل 2 المان باید باشه (botName, fileId)، المان سوم (username) اختیاری هست
+	entries := make([]zipEntry, 0, len(requestData))
+	for _, data := range requestData {
+		if len(data) < 2 {
+			return nil, fiber.NewError(400, "data format error: each item needs at least [botName, fileId]")
+		}
+
+		entry := zipEntry{BotName: data[0], FileId: data[1], Name: data[1]}
+		if len(data) >= 3 && data[2] != "" {
+			entry.Name = data[2]
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// parseZipJSONBody decodes the application/json array of zipRequestItem
+func parseZipJSONBody(body []byte) ([]zipEntry, error) {
+	var items []zipRequestItem
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, fiber.NewError(400, err.Error())
+	}
+
+	if len(items) > maxZipFiles {
+		return nil, fiber.NewError(400, fmt.Sprintf("too many files: %d exceeds maximum of %d", len(items), maxZipFiles))
+	}
+
+	entries := make([]zipEntry, 0, len(items))
+	for i, item := range items {
+		entry, err := zipEntryFromItem(item)
+		if err != nil {
+			return nil, fiber.NewError(400, fmt.Sprintf("item %d: %v", i, err))
+		}
+
+		if entry.Folder, err = cleanZipFolder(item.Folder); err != nil {
+			return nil, fiber.NewError(400, fmt.Sprintf("item %d: %v", i, err))
+		}
+		if item.Name != "" {
+			entry.Name = item.Name
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// zipEntryFromItem validates the source of one JSON item: exactly one of scope+fileId,
+// bucket+key (an allowed bucket) or url (a public http(s) link)
+func zipEntryFromItem(item zipRequestItem) (zipEntry, error) {
+	sources := 0
+	for _, set := range []bool{item.Scope != "" || item.FileId != "", item.Bucket != "" || item.Key != "", item.URL != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return zipEntry{}, fmt.Errorf("data format error: needs exactly one of scope and fileId, bucket and key, or url")
+	}
+
+	switch {
+	case item.URL != "":
+		if err := validateExternalURL(item.URL); err != nil {
+			return zipEntry{}, err
+		}
+		name := "file"
+		if parsed, err := url.Parse(item.URL); err == nil {
+			if base := path.Base(parsed.Path); base != "/" && base != "." {
+				name = strings.TrimSuffix(base, path.Ext(base))
+			}
+		}
+		return zipEntry{URL: item.URL, Name: name}, nil
+
+	case item.Bucket != "" || item.Key != "":
+		if item.Bucket == "" || item.Key == "" {
+			return zipEntry{}, fmt.Errorf("data format error: needs bucket and key")
+		}
+		if !utils.IsValidBucket(item.Bucket) {
+			return zipEntry{}, fmt.Errorf("bucket %s not found", item.Bucket)
+		}
+		base := path.Base(item.Key)
+		return zipEntry{Bucket: item.Bucket, Key: item.Key, Name: strings.TrimSuffix(base, path.Ext(base))}, nil
+	}
+
+	if item.Scope == "" || item.FileId == "" {
+		return zipEntry{}, fmt.Errorf("data format error: needs scope and fileId")
+	}
+	return zipEntry{BotName: item.Scope, FileId: item.FileId, Name: item.FileId, Bot: item.Bot}, nil
+}
+
+// cleanZipFolder normalises a folder to a relative slash path inside the archive
+func cleanZipFolder(folder string) (string, error) {
+	folder = strings.ReplaceAll(folder, "\\", "/")
+	for _, part := range strings.Split(folder, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("folder %q leaves the archive", folder)
+		}
+	}
+	// path.Clean روی مسیر مطلق نمی‌تونه از ریشه بیرون بره
+	return strings.TrimPrefix(path.Clean("/"+folder), "/"), nil
+}
+
+// parseZipMode reads ?mode=strict|best-effort (default strict)
+func parseZipMode(ctx *fiber.Ctx) (string, error) {
+	switch mode := ctx.Query("mode", zipModeStrict); mode {
+	case zipModeStrict:
+		return zipModeStrict, nil
+	case zipModeBestEffort:
+		// ctx.Query به بافر درخواست اشاره می‌کنه و بعد از پاسخ عوض میشه
+		return zipModeBestEffort, nil
+	default:
+		return "", fiber.NewError(400, fmt.Sprintf("unknown mode '%s', expected %s or %s", mode, zipModeStrict, zipModeBestEffort))
+	}
+}
+
+// zipBuilder reads the requested files from the /instant cache or Telegram and writes them into an archive
+type zipBuilder struct {
+	// minioClient gives access to the scope buckets that cache Telegram files (optional)
+	minioClient    *config.MinIOClients
+	botScopeConfig *config.BotScopeConfiguration
+	recordStore    *record_store.Store
+	opts           zipBuildOptions
+	// progress is called after each file is added or has failed (optional)
+	progress func(manifest *ZipManifest)
+	// endpoint labels the build in the zip telemetry
+	endpoint string
+}
+
+// fetch opens one entry: a cached copy in the scope bucket is streamed from MinIO,
+// otherwise the file is downloaded with the scope's racing strategy and cached
+func (b zipBuilder) fetch(ctx context.Context, index int, entry zipEntry) zipFile {
+	result := zipFile{index: index, entry: entry}
+
+	switch {
+	case entry.Bucket != "":
+		result.err = b.fetchObject(ctx, &result)
+		return result
+	case entry.URL != "":
+		attempts := max(b.opts.Attempts, 1)
+		for attempt := 1; attempt <= attempts; attempt++ {
+			result.err = b.fetchLink(ctx, &result)
+			if result.err == nil {
+				break
+			}
+			if attempt < attempts {
+				log.Printf("Download attempt %d failed for %s, retrying: %v", attempt, entry.URL, result.err)
+				time.Sleep(time.Millisecond * 100)
+			}
+		}
+		return result
+	}
+
+	scope := strings.ToLower(entry.BotName)
+
+	var cache *telegramCache
+	if b.minioClient != nil && utils.IsValidBucket(scope) {
+		scopeCache := newTelegramCache(b.minioClient, scope)
+		cache = &scopeCache
+
+		if key, found := cache.lookup(ctx, entry.FileId); found {
+			if b.openCached(ctx, &result, cache, key) {
+				return result
+			}
+		}
+	}
+
+	namedBots := b.botScopeConfig.GetNamedBots(scope)
+	if len(namedBots) == 0 {
+		result.err = fmt.Errorf("no bot APIs available for %s", entry.BotName)
+		return result
+	}
+
+	ownerBotName := ""
+	if entry.Bot == "" {
+		ownerBotName, _ = lookupFileAffinity(ctx, b.recordStore, scope, entry.FileId)
+	}
+
+	attempts := max(b.opts.Attempts, 1)
+	for attempt := 1; attempt <= attempts; attempt++ {
+		result.err = b.fetchFromTelegram(ctx, &result, cache, namedBots, scope, ownerBotName)
+		if result.err == nil {
+			break
+		}
+		if attempt < attempts {
+			log.Printf("Download attempt %d failed for %s, retrying: %v", attempt, entry.FileId, result.err)
+			time.Sleep(time.Millisecond * 100)
+		}
+	}
+	return result
+}
+
+// openCached points result at a cached object; false means it has to come from Telegram
+func (b zipBuilder) openCached(ctx context.Context, result *zipFile, cache *telegramCache, key string) bool {
+	body, size, contentType, err := cache.open(ctx, key)
+	if err != nil {
+		log.Printf("⚠️ Cached object %s unreadable, downloading from Telegram: %v", key, err)
+		return false
+	}
+
+	log.Printf("✅ Cache HIT for FileID: %s (Key: %s, %d bytes)", result.entry.FileId, key, size)
+	result.body = body
+	result.size = size
+	result.contentType = contentType
+	result.extension = zipExtension(contentType)
+	result.source = zipSourceCache
+	return true
+}
+
+// fetchFromTelegram opens the download of an entry that is not cached; the cache is
+// filled while the archive reads it
+func (b zipBuilder) fetchFromTelegram(ctx context.Context, result *zipFile, cache *telegramCache, namedBots []config.NamedBot, scope, ownerBotName string) error {
+	fileId := result.entry.FileId
+
+	info, selectedBotApi, resolvedBotName, strategy, err := resolveRequestedTelegramFile(ctx, namedBots, fileId, result.entry.Bot, ownerBotName, getScopeStrategy(scope, ""))
+	if err != nil {
+		return err
+	}
+
+	// همون فایل ممکنه با file_id یه بات دیگه قبلا کش شده باشه
+	if cache != nil {
+		if key, found := cache.findObject(ctx, info.FileUniqueId); found {
+			_ = cache.writeAlias(ctx, fileId, key)
+			if b.openCached(ctx, result, cache, key) {
+				return nil
+			}
+		}
+	}
+
+	stream, usedBotName, err := openResolvedFile(ctx, namedBots, info, selectedBotApi, resolvedBotName, strategy)
+	if err != nil {
+		return err
+	}
+
+	// Determine file extension from the first bytes, without reading the rest
+	buffered := bufio.NewReaderSize(stream.body, 32*1024)
+	head, err := buffered.Peek(512)
+	if err != nil && err != io.EOF {
+		_ = stream.body.Close()
+		return fmt.Errorf("failed to read download: %w", err)
+	}
+
+	mimeType := http.DetectContentType(head)
+	if strings.Contains(mimeType, "text/plain") && stream.contentType != "" {
+		mimeType = stream.contentType
+	}
+
+	var body io.ReadCloser = struct {
+		io.Reader
+		io.Closer
+	}{buffered, stream.body}
+	if cache != nil {
+		extension := determineFileExtension(head, stream.contentType, fileId)
+		body = cache.storeWhileReading(fileId, info.FileUniqueId, extension, getContentTypeFromExtension(extension), body, stream.size)
+	}
+
+	body, size, err := b.sizedBody(body, stream.size)
+	if err != nil {
+		return err
+	}
+
+	result.body = body
+	result.size = size
+	result.contentType = mimeType
+	result.extension = zipExtension(mimeType)
+	result.source = zipSourceTelegram
+	result.servedBy = usedBotName
+	return nil
+}
+
+// sizedBody reads a body of unknown size into memory when the format needs sizes up front
+func (b zipBuilder) sizedBody(body io.ReadCloser, size int64) (io.ReadCloser, int64, error) {
+	if size >= 0 || !b.opts.Format.needsSize() {
+		return body, size, nil
+	}
+
+	// tar باید اندازه رو قبل از داده بنویسه
+	data, err := io.ReadAll(body)
+	_ = body.Close()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read download: %w", err)
+	}
+	return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+// fetchObject streams a MinIO object; the entry keeps the extension of its key
+func (b zipBuilder) fetchObject(ctx context.Context, result *zipFile) error {
+	entry := result.entry
+	if b.minioClient == nil {
+		return fmt.Errorf("object storage is not available")
+	}
+	// باکت‌ها ممکنه بعد از درخواست با reload کانفیگ عوض شده باشن
+	if !utils.IsValidBucket(entry.Bucket) {
+		return fmt.Errorf("bucket %s not found", entry.Bucket)
+	}
+
+	object, err := b.minioClient.Storage.Conn().GetObject(ctx, entry.Bucket, entry.Key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	info, err := object.Stat()
+	if err != nil {
+		_ = object.Close()
+		return err
+	}
+
+	result.body = object
+	result.size = info.Size
+	result.contentType = info.ContentType
+	result.extension = strings.TrimPrefix(path.Ext(entry.Key), ".")
+	if result.extension == "" {
+		result.extension = zipExtension(info.ContentType)
+	}
+	result.source = zipSourceBucket
+	return nil
+}
+
+// externalLinkClient downloads external links (zip entries, album links); redirects are
+// validated like the link itself
+var externalLinkClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: 60 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		return validateExternalURL(req.URL.String())
+	},
+}
+
+// getZipLinkTimeout reads ZIP_LINK_TIMEOUT (default 10m), the deadline of one link download body included
+func getZipLinkTimeout() time.Duration {
+	if v := os.Getenv("ZIP_LINK_TIMEOUT"); v != "" {
+		if timeout, err := time.ParseDuration(v); err == nil && timeout > 0 {
+			return timeout
+		}
+		log.Printf("⚠️ Invalid ZIP_LINK_TIMEOUT '%s', using 10m", v)
+	}
+	return 10 * time.Minute
+}
+
+// fetchLink streams an external link of at most maxDownloadSize bytes
+func (b zipBuilder) fetchLink(ctx context.Context, result *zipFile) error {
+	link := result.entry.URL
+	if err := validateExternalURL(link); err != nil {
+		return err
+	}
+
+	// سرور کند نباید آرشیو رو تا ابد نگه داره؛ مهلت تا بسته شدن بدنه ادامه داره
+	linkCtx, cancelLink := context.WithTimeout(ctx, getZipLinkTimeout())
+	req, err := http.NewRequestWithContext(linkCtx, "GET", link, nil)
+	if err != nil {
+		cancelLink()
+		return err
+	}
+	res, err := externalLinkClient.Do(req)
+	if err != nil {
+		cancelLink()
+		return err
+	}
+	res.Body = &cancelingBody{ReadCloser: res.Body, cancel: cancelLink}
+	if res.StatusCode != 200 {
+		_ = res.Body.Close()
+		return fmt.Errorf("link returned status %d", res.StatusCode)
+	}
+	if res.ContentLength > maxDownloadSize {
+		_ = res.Body.Close()
+		return fmt.Errorf("link is %d bytes, more than %d", res.ContentLength, maxDownloadSize)
+	}
+
+	buffered := bufio.NewReaderSize(res.Body, 32*1024)
+	head, err := buffered.Peek(512)
+	if err != nil && err != io.EOF {
+		_ = res.Body.Close()
+		return fmt.Errorf("failed to read download: %w", err)
+	}
+
+	mimeType := http.DetectContentType(head)
+	if headerType := res.Header.Get("Content-Type"); strings.Contains(mimeType, "text/plain") && headerType != "" {
+		mimeType = headerType
+	}
+
+	var body io.ReadCloser = struct {
+		io.Reader
+		io.Closer
+	}{telegram_api.CapReader(buffered, maxDownloadSize, fmt.Errorf("download is larger than %d bytes", maxDownloadSize)), res.Body}
+	body, size, err := b.sizedBody(body, res.ContentLength)
+	if err != nil {
+		return err
+	}
+
+	result.body = body
+	result.size = size
+	result.contentType = mimeType
+	result.extension = zipExtension(mimeType)
+	result.source = zipSourceLink
+	return nil
+}
+
+// cancelingBody releases the context of a download when its body is closed
+type cancelingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// zipExtension names zip entries after the MIME subtype, e.g. image/jpeg -> jpeg
+func zipExtension(mimeType string) string {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	if parts := strings.Split(mimeType, "/"); len(parts) == 2 {
+		return parts[1]
+	}
+	return "bin"
+}
+
+// download opens every entry concurrently and delivers them in completion order.
+// Slots are taken in request order and held until the entry's body is closed, so at
+// most MaxConcurrent downloads are open and the file the archive waits for always has one.
+func (b zipBuilder) download(ctx context.Context, entries []zipEntry) <-chan zipFile {
+	results := make(chan zipFile, len(entries))
+
+	maxConcurrent := b.opts.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = len(entries)
+	}
+	semaphore := make(chan struct{}, max(maxConcurrent, 1))
+
+	go func() {
+		var wg sync.WaitGroup
+		for i, entry := range entries {
+			semaphore <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				log.Printf("Starting download %d/%d: %s (name: %s)", i+1, len(entries), entry.ref(), entry.Name)
+				started := time.Now()
+				result := b.fetch(ctx, i, entry)
+				result.fetchTime = time.Since(started)
+
+				release := func() { <-semaphore }
+				if result.body == nil {
+					release()
+				} else {
+					result.body = &releasingBody{ReadCloser: result.body, release: release}
+				}
+				results <- result
+			}()
+		}
+
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// releasingBody frees a download slot when the body is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// build writes the archive to w in the configured format, keeping the files in request order.
+// In strict mode the first failed file aborts the build with its error; in best-effort mode
+// failed files are skipped and listed in manifest.json and errors.txt at the end of the archive.
+// Downloads still open when the build returns are cancelled.
+func (b zipBuilder) build(parent context.Context, w io.Writer, archiveName string, entries []zipEntry) (manifest *ZipManifest, err error) {
+	started := time.Now()
+	defer func() { recordZipTelemetry(b, manifest, time.Since(started), err) }()
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	manifest = &ZipManifest{
+		Archive:   archiveName,
+		Mode:      b.opts.Mode,
+		Total:     len(entries),
+		CreatedAt: time.Now(),
+		Entries:   make([]ZipManifestEntry, len(entries)),
+	}
+	for i, entry := range entries {
+		manifest.Entries[i] = ZipManifestEntry{
+			BotName: entry.BotName,
+			FileId:  entry.FileId,
+			Bucket:  entry.Bucket,
+			Key:     entry.Key,
+			URL:     entry.URL,
+			Name:    entry.Name,
+			Folder:  entry.Folder,
+			Bot:     entry.Bot,
+			Status:  "pending",
+		}
+	}
+
+	format := b.opts.Format
+	if format.Name == "" {
+		format = archiveFormats[formatZip]
+	}
+	archive, err := format.newWriter(w, b.opts.Password)
+	if err != nil {
+		return manifest, err
+	}
+
+	log.Printf("Starting %s creation for %d files (mode: %s)", format.Name, len(entries), b.opts.Mode)
+
+	// نام‌ها به ترتیب ورودی رزرو میشن تا آرشیو یک درخواست همیشه یکسان باشه
+	names := zipEntryNames{}
+	if b.opts.Mode == zipModeBestEffort {
+		names.reserve("manifest.json")
+		names.reserve("errors.txt")
+	}
+
+	results := b.download(ctx, entries)
+
+	// Files finish in any order; pending holds them until every earlier file is written
+	pending := make(map[int]zipFile)
+	next := 0
+	abort := func(err error) (*ZipManifest, error) {
+		for _, result := range pending {
+			result.close()
+		}
+		go discardZipFiles(results)
+		return manifest, err
+	}
+
+	for result := range results {
+		if result.err != nil && b.opts.Mode != zipModeBestEffort {
+			b.markFailed(manifest, result)
+			return abort(result.err)
+		}
+
+		pending[result.index] = result
+		for {
+			current, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+
+			if err := b.addFile(archive, manifest, names, current); err != nil {
+				return abort(err)
+			}
+		}
+	}
+
+	if b.opts.Mode == zipModeBestEffort {
+		if err := writeZipManifest(archive, manifest); err != nil {
+			return manifest, err
+		}
+	}
+
+	if err := archive.setComment(manifest.summary()); err != nil {
+		return manifest, err
+	}
+	if err := archive.close(); err != nil {
+		return manifest, err
+	}
+
+	log.Printf("Finished %s %s: %s", format.Name, archiveName, manifest.summary())
+	return manifest, nil
+}
+
+// markFailed records a file that could not be downloaded
+func (b zipBuilder) markFailed(manifest *ZipManifest, result zipFile) {
+	log.Printf("Error downloading file %s: %v", result.entry.ref(), result.err)
+	item := &manifest.Entries[result.index]
+	item.Source = result.source
+	item.ServedBy = result.servedBy
+	item.DurationMs = result.fetchTime.Milliseconds()
+	item.Status = "failed"
+	item.Error = result.err.Error()
+	manifest.Failed++
+	b.reportProgress(manifest)
+}
+
+// addFile writes one downloaded file into the archive, or lists it as failed in best-effort mode
+func (b zipBuilder) addFile(archive archiveWriter, manifest *ZipManifest, names zipEntryNames, result zipFile) error {
+	if result.err != nil {
+		b.markFailed(manifest, result)
+		return nil
+	}
+
+	item := &manifest.Entries[result.index]
+	item.Source = result.source
+	item.ServedBy = result.servedBy
+	item.DurationMs = result.fetchTime.Milliseconds()
+
+	// استفاده از fileName به جای fileID
+	entryName := names.reserve(zipEntryPath(result.entry, result.extension))
+	zipFileWriter, err := archive.create(entryName, result.size, zipMethodFor(result.contentType, b.opts.Method), time.Now())
+	if err != nil {
+		log.Printf("Error creating zip entry for %s: %v", result.entry.Name, err)
+		result.close()
+		return err
+	}
+
+	// Stream the file in chunks; the zip writer computes the CRC as it goes
+	writeStarted := time.Now()
+	source := &sourceReader{r: result.body}
+	written, err := io.CopyBuffer(zipFileWriter, source, make([]byte, 32*1024))
+	result.close()
+	item.DurationMs += time.Since(writeStarted).Milliseconds()
+	if err != nil && source.err != nil && b.opts.Mode == zipModeBestEffort {
+		// خطا از سمت منبع بوده نه آرشیو، پس بقیه فایل‌ها هنوز قابل نوشتن هستن
+		return b.markTruncated(zipFileWriter, manifest, entryName, result, written, source.err)
+	}
+	if err != nil {
+		log.Printf("Error writing file data for %s: %v", result.entry.Name, err)
+		return err
+	}
+
+	item.Entry = entryName
+	item.Status = "ok"
+	item.Size = written
+	manifest.Succeeded++
+	manifest.Bytes += written
+	log.Printf("Added file %s (ID: %s, from %s) to zip (%d/%d) - %d bytes, total: %d bytes",
+		entryName, result.entry.ref(), result.source, manifest.Succeeded+manifest.Failed, manifest.Total, written, manifest.Bytes)
+	b.reportProgress(manifest)
+	return nil
+}
+
+// sourceReader remembers a read error of the source, to tell it from an archive write error
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+// markTruncated keeps an entry whose source failed partway (best-effort mode) and lists it
+// as failed. Tar entries are padded with zeros to the size in their header so the archive
+// stays readable; a zip entry simply ends where the source did.
+func (b zipBuilder) markTruncated(w io.Writer, manifest *ZipManifest, entryName string, result zipFile, written int64, readErr error) error {
+	if b.opts.Format.needsSize() && written < result.size {
+		zeros := make([]byte, 32*1024)
+		for left := result.size - written; left > 0; {
+			n, err := w.Write(zeros[:min(left, int64(len(zeros)))])
+			if err != nil {
+				return err
+			}
+			left -= int64(n)
+		}
+	}
+
+	log.Printf("⚠️ Source of %s failed after %d bytes, entry %s is truncated: %v", result.entry.ref(), written, entryName, readErr)
+	item := &manifest.Entries[result.index]
+	item.Entry = entryName
+	item.Status = "failed"
+	item.Size = written
+	item.Error = fmt.Sprintf("truncated after %d bytes: %v", written, readErr)
+	manifest.Failed++
+	b.reportProgress(manifest)
+	return nil
+}
+
+// zipEntryPath places an entry under its folder, named after the requested name
+func zipEntryPath(entry zipEntry, extension string) string {
+	name := path.Base(strings.ReplaceAll(entry.Name, "\\", "/"))
+	if name == "/" || name == "." || name == ".." {
+		name = cmp.Or(entry.FileId, "file")
+	}
+	return path.Join(entry.Folder, name+"."+extension)
+}
+
+// zipEntryNames hands out unique entry names; a taken name gets a " (n)" suffix.
+// Names are compared case-insensitively so archives extract cleanly on Windows and macOS.
+type zipEntryNames map[string]bool
+
+// reserve returns name, or the first free "name (n).ext" when name is taken
+func (n zipEntryNames) reserve(name string) string {
+	candidate := name
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 2; n[strings.ToLower(candidate)]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	n[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// discardZipFiles closes the entries still arriving after a build was aborted
+func discardZipFiles(results <-chan zipFile) {
+	for result := range results {
+		result.close()
+	}
+}
+
+func (b zipBuilder) reportProgress(manifest *ZipManifest) {
+	if b.progress != nil {
+		b.progress(manifest)
+	}
+}
+
+// writeZipManifest appends manifest.json and errors.txt as the last entries
+func writeZipManifest(archive archiveWriter, manifest *ZipManifest) error {
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	var errorsTxt strings.Builder
+	fmt.Fprintf(&errorsTxt, "%s\n", manifest.summary())
+	for _, item := range manifest.Entries {
+		if item.Status == "failed" {
+			switch {
+			case item.Bucket != "":
+				fmt.Fprintf(&errorsTxt, "[%s/%s, %s] %s\n", item.Bucket, item.Key, item.Name, item.Error)
+			case item.URL != "":
+				fmt.Fprintf(&errorsTxt, "[%s, %s] %s\n", item.URL, item.Name, item.Error)
+			default:
+				fmt.Fprintf(&errorsTxt, "[%s, %s, %s] %s\n", item.BotName, item.FileId, item.Name, item.Error)
+			}
+		}
+	}
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"manifest.json", manifestJSON},
+		{"errors.txt", []byte(errorsTxt.String())},
+	}
+	for _, file := range files {
+		entryWriter, err := archive.create(file.name, int64(len(file.data)), zip.Deflate, time.Now())
+		if err != nil {
+			return err
+		}
+		if _, err := entryWriter.Write(file.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamZip builds the archive in the background and streams it as the response body
+func streamZip(ctx *fiber.Ctx, builder zipBuilder, archiveName string, entries []zipEntry) error {
+	// Set response headers immediately for streaming
+	ctx.Set("Content-Type", builder.opts.Format.ContentType)
+	ctx.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", archiveName))
+	ctx.Set("Transfer-Encoding", "chunked")
+	ctx.Set("Cache-Control", "no-cache")
+	ctx.Set("X-Zip-Mode", builder.opts.Mode)
+
+	pipeReader, pipeWriter := io.Pipe()
+
+	go func() {
+		_, err := builder.build(context.Background(), pipeWriter, archiveName, entries)
+		if err != nil {
+			log.Printf("❌ Archive %s aborted: %v", archiveName, err)
+		}
+		// CloseWithError(nil) همون Close معمولیه
+		_ = pipeWriter.CloseWithError(err)
+	}()
+
+	// Stream the zip data directly to the client
+	ctx.Context().SetBodyStream(pipeReader, -1)
+	return nil
+}
@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go-uploader/config"
+	"go-uploader/pkg/telegram_api"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingBody returns data and then fails like a dropped download
+func failingBody(data string, err error) io.ReadCloser {
+	return io.NopCloser(io.MultiReader(strings.NewReader(data), &errorReader{err: err}))
+}
+
+type errorReader struct{ err error }
+
+func (r *errorReader) Read([]byte) (int, error) { return 0, r.err }
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("client went away") }
+
+// readTestArchive lists the entries of a zip or tar archive with their contents
+func readTestArchive(t *testing.T, format string, data []byte) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	if format == formatZip {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("archive is not a valid zip: %v", err)
+		}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("%s: %v", f.Name, err)
+			}
+			files[f.Name] = string(content)
+		}
+		return files
+	}
+
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatalf("archive is not a valid tar: %v", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("%s: %v", header.Name, err)
+		}
+		files[header.Name] = string(content)
+	}
+}
+
+func TestAddFileSourceErrors(t *testing.T) {
+	readErr := errors.New("connection reset by peer")
+
+	tests := []struct {
+		name       string
+		format     string
+		mode       string
+		wantAbort  bool
+		wantBroken string
+	}{
+		{"zip best-effort keeps going", formatZip, zipModeBestEffort, false, "hello"},
+		{"tar best-effort pads the entry", formatTar, zipModeBestEffort, false, "hello\x00\x00\x00\x00\x00"},
+		{"zip strict aborts", formatZip, zipModeStrict, true, ""},
+		{"tar strict aborts", formatTar, zipModeStrict, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format := archiveFormats[tt.format]
+			b := zipBuilder{opts: zipBuildOptions{Mode: tt.mode, Format: format, Method: zip.Deflate}}
+			var buf bytes.Buffer
+			archive, err := format.newWriter(&buf, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			manifest := &ZipManifest{Total: 2, Entries: make([]ZipManifestEntry, 2)}
+			names := zipEntryNames{}
+
+			broken := zipFile{index: 0, entry: zipEntry{FileId: "F1", Name: "broken"}, extension: "txt", contentType: "text/plain",
+				body: failingBody("hello", readErr), size: 10}
+			err = b.addFile(archive, manifest, names, broken)
+			if tt.wantAbort {
+				if !errors.Is(err, readErr) {
+					t.Fatalf("addFile error = %v, want the read error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("addFile error = %v, want the build to continue", err)
+			}
+
+			good := zipFile{index: 1, entry: zipEntry{FileId: "F2", Name: "good"}, extension: "txt", contentType: "text/plain",
+				body: io.NopCloser(strings.NewReader("world")), size: 5}
+			if err := b.addFile(archive, manifest, names, good); err != nil {
+				t.Fatal(err)
+			}
+			if err := archive.close(); err != nil {
+				t.Fatal(err)
+			}
+
+			files := readTestArchive(t, tt.format, buf.Bytes())
+			if files["broken.txt"] != tt.wantBroken || files["good.txt"] != "world" {
+				t.Fatalf("archive files = %q", files)
+			}
+			if item := manifest.Entries[0]; item.Status != "failed" || item.Size != 5 || !strings.Contains(item.Error, "truncated") {
+				t.Errorf("broken entry = %+v, want failed and truncated after 5 bytes", item)
+			}
+			if manifest.Failed != 1 || manifest.Succeeded != 1 {
+				t.Errorf("manifest = %d ok / %d failed, want 1 / 1", manifest.Succeeded, manifest.Failed)
+			}
+		})
+	}
+}
+
+func TestAddFileWriterErrorAborts(t *testing.T) {
+	format := archiveFormats[formatTar]
+	b := zipBuilder{opts: zipBuildOptions{Mode: zipModeBestEffort, Format: format}}
+	archive, err := format.newWriter(failingWriter{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := &ZipManifest{Total: 1, Entries: make([]ZipManifestEntry, 1)}
+
+	file := zipFile{entry: zipEntry{FileId: "F1", Name: "a"}, extension: "txt", body: io.NopCloser(strings.NewReader("hello")), size: 5}
+	if err := b.addFile(archive, manifest, zipEntryNames{}, file); err == nil {
+		t.Fatal("addFile succeeded although the archive could not be written")
+	}
+}
+
+func TestZipEntryNamesReserve(t *testing.T) {
+	tests := []struct {
+		name     string
+		reserved []string
+		want     []string
+	}{
+		{"unique names are kept", []string{"a.jpeg", "b.jpeg", "dir/a.jpeg"}, []string{"a.jpeg", "b.jpeg", "dir/a.jpeg"}},
+		{"duplicates are numbered", []string{"a.jpeg", "a.jpeg", "a.jpeg"}, []string{"a.jpeg", "a (2).jpeg", "a (3).jpeg"}},
+		{"case-insensitive", []string{"Photo.JPEG", "photo.jpeg", "PHOTO.jpeg"}, []string{"Photo.JPEG", "photo (2).jpeg", "PHOTO (3).jpeg"}},
+		{"numbered name already taken", []string{"a (2).txt", "a.txt", "a.txt"}, []string{"a (2).txt", "a.txt", "a (3).txt"}},
+		{"no extension", []string{"README", "README"}, []string{"README", "README (2)"}},
+		{"reserved manifest", []string{"manifest.json", "errors.txt", "manifest.json"}, []string{"manifest.json", "errors.txt", "manifest (2).json"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names := zipEntryNames{}
+			got := make([]string, len(tt.reserved))
+			for i, name := range tt.reserved {
+				got[i] = names.reserve(name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("reserve = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZipEntryPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry zipEntry
+		want  string
+	}{
+		{"name and extension", zipEntry{FileId: "F1", Name: "photo"}, "photo.jpeg"},
+		{"folder", zipEntry{FileId: "F1", Name: "photo", Folder: "trip/day 1"}, "trip/day 1/photo.jpeg"},
+		{"path in the name keeps the last segment", zipEntry{FileId: "F1", Name: "../../etc/photo"}, "photo.jpeg"},
+		{"backslashes", zipEntry{FileId: "F1", Name: `a\b\photo`}, "photo.jpeg"},
+		{"dot name falls back to the file id", zipEntry{FileId: "F1", Name: ".."}, "F1.jpeg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := zipEntryPath(tt.entry, "jpeg"); got != tt.want {
+				t.Errorf("zipEntryPath = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestTelegram serves getFile and downloads for file ids "F<n>"; a file's download
+// takes delay(n) so tests can make files finish in any order
+func newTestTelegram(t *testing.T, delay func(n int) time.Duration) *config.BotScopeConfiguration {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/getFile") {
+			body, _ := io.ReadAll(r.Body)
+			fileId := strings.Split(strings.Split(string(body), `"file_id":"`)[1], `"`)[0]
+			fmt.Fprintf(w, `{"ok":true,"result":{"file_id":%q,"file_unique_id":"u%s","file_path":"documents/%s.txt"}}`, fileId, fileId, fileId)
+			return
+		}
+		fileId := strings.TrimSuffix(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:], ".txt")
+		n, _ := strconv.Atoi(strings.TrimPrefix(fileId, "F"))
+		time.Sleep(delay(n))
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "content of %s", fileId)
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("TELEGRAM_API_BASE_URL", server.URL)
+	t.Setenv("TELEGRAM_DIRECT_DOWNLOAD", "")
+
+	return &config.BotScopeConfiguration{Scopes: map[string][]config.NamedBot{
+		"telegram": {{Name: "relic", API: telegram_api.New("123:TOKEN")}},
+	}}
+}
+
+func TestBuildKeepsRequestOrder(t *testing.T) {
+	const files = 5
+
+	tests := []struct {
+		name  string
+		delay func(n int) time.Duration
+	}{
+		{"last file finishes first", func(n int) time.Duration { return time.Duration(files-n) * 15 * time.Millisecond }},
+		{"first file finishes last", func(n int) time.Duration {
+			if n == 0 {
+				return 60 * time.Millisecond
+			}
+			return 0
+		}},
+		{"in order", func(n int) time.Duration { return time.Duration(n) * 5 * time.Millisecond }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := zipBuilder{
+				botScopeConfig: newTestTelegram(t, tt.delay),
+				opts:           zipBuildOptions{Mode: zipModeStrict, Format: archiveFormats[formatZip], Method: zip.Deflate},
+			}
+
+			// every entry asks for the same name, so the numbering shows the order too
+			entries := make([]zipEntry, files)
+			for i := range entries {
+				entries[i] = zipEntry{BotName: "telegram", FileId: fmt.Sprintf("F%d", i), Name: "report"}
+			}
+
+			var buf bytes.Buffer
+			manifest, err := b.build(context.Background(), &buf, "test.zip", entries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if manifest.Succeeded != files {
+				t.Fatalf("manifest = %s, want %d files", manifest.summary(), files)
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, f := range zr.File {
+				wantName := "report.plain"
+				if i > 0 {
+					wantName = fmt.Sprintf("report (%d).plain", i+1)
+				}
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				content, _ := io.ReadAll(rc)
+				rc.Close()
+				if f.Name != wantName || string(content) != fmt.Sprintf("content of F%d", i) {
+					t.Errorf("entry %d = %s %q, want %s with F%d", i, f.Name, content, wantName, i)
+				}
+			}
+		})
+	}
+}
+
+func TestExternalLinkClientRedirects(t *testing.T) {
+	tests := []struct {
+		target  string
+		wantErr bool
+	}{
+		{"https://93.184.215.14/file.jpg", false},
+		{"http://127.0.0.1/admin", true},
+		{"http://169.254.169.254/latest/meta-data/", true},
+		{"http://10.0.0.5/internal", true},
+		{"file:///etc/passwd", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tt.target, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = externalLinkClient.CheckRedirect(req, []*http.Request{{}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("redirect to %s: error = %v, wantErr %v", tt.target, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,559 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-uploader/config"
+	"go-uploader/models"
+	"go-uploader/pkg/record_store"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+const zipJobPrefix = "zipjobs/"
+
+// Zip job states
+const (
+	zipJobQueued  = "queued"
+	zipJobRunning = "running"
+	zipJobDone    = "done"
+	zipJobFailed  = "failed"
+)
+
+// zipJobSaveInterval throttles how often progress is written to the record store
+const zipJobSaveInterval = time.Second
+
+// Job ids are the sha256 archive ids of parseZipRequest and archiveFormat.archiveId;
+// encrypted jobs get a random one since their archive only opens with their own password,
+// and so do jobs with bucket or link entries, whose content can change under the same request
+var zipJobIdPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ZipJob is an archive built in the background into ZIP_JOBS_BUCKET
+type ZipJob struct {
+	Id         string             `json:"id"`
+	Status     string             `json:"status"`
+	Mode       string             `json:"mode"`
+	Format     string             `json:"format"`
+	Bucket     string             `json:"bucket"`
+	ObjectKey  string             `json:"objectKey"`
+	Encrypted  bool               `json:"encrypted,omitempty"`
+	Total      int                `json:"total"`
+	FilesDone  int                `json:"filesDone"`
+	Succeeded  int                `json:"succeeded"`
+	Failed     int                `json:"failed"`
+	Bytes      int64              `json:"bytes"`
+	Size       int64              `json:"size,omitempty"`
+	Failures   []ZipManifestEntry `json:"failures,omitempty"`
+	Error      string             `json:"error,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt"`
+	UpdatedAt  time.Time          `json:"updatedAt"`
+	FinishedAt *time.Time         `json:"finishedAt,omitempty"`
+}
+
+// format returns the archive format of a job; jobs recorded before formats existed are zip
+func (j ZipJob) format() archiveFormat {
+	if format, ok := archiveFormats[j.Format]; ok {
+		return format
+	}
+	return archiveFormats[formatZip]
+}
+
+func zipJobKey(id string) string {
+	return zipJobPrefix + id + ".json"
+}
+
+var (
+	// activeZipJobs holds the jobs of this instance until they finish
+	activeZipJobs   = map[string]*ZipJob{}
+	activeZipJobsMu sync.Mutex
+
+	zipJobSlots     chan struct{}
+	zipJobSlotsOnce sync.Once
+)
+
+// getZipJobsBucket reads ZIP_JOBS_BUCKET (default zip-jobs)
+func getZipJobsBucket() string {
+	if bucket := os.Getenv("ZIP_JOBS_BUCKET"); bucket != "" {
+		return bucket
+	}
+	return "zip-jobs"
+}
+
+// getZipJobTimeout reads ZIP_JOB_TIMEOUT (default 30m), which includes the time spent queued
+func getZipJobTimeout() time.Duration {
+	if v := os.Getenv("ZIP_JOB_TIMEOUT"); v != "" {
+		if timeout, err := time.ParseDuration(v); err == nil && timeout > 0 {
+			return timeout
+		}
+		log.Printf("⚠️ Invalid ZIP_JOB_TIMEOUT '%s', using 30m", v)
+	}
+	return 30 * time.Minute
+}
+
+// getZipJobLinkTTL reads ZIP_JOB_LINK_TTL (default 1h), the lifetime of presigned download links
+func getZipJobLinkTTL() time.Duration {
+	if v := os.Getenv("ZIP_JOB_LINK_TTL"); v != "" {
+		if ttl, err := time.ParseDuration(v); err == nil && ttl > 0 && ttl <= 7*24*time.Hour {
+			return ttl
+		}
+		log.Printf("⚠️ Invalid ZIP_JOB_LINK_TTL '%s', using 1h", v)
+	}
+	return time.Hour
+}
+
+// acquireZipJobSlot limits concurrently built jobs to ZIP_JOBS_WORKERS (default 2)
+func acquireZipJobSlot(ctx context.Context) (func(), error) {
+	zipJobSlotsOnce.Do(func() {
+		workers := 2
+		if v := os.Getenv("ZIP_JOBS_WORKERS"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				workers = n
+			}
+		}
+		zipJobSlots = make(chan struct{}, workers)
+	})
+
+	select {
+	case zipJobSlots <- struct{}{}:
+		return func() { <-zipJobSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// ensureZipJobsBucket creates the jobs bucket if it does not exist yet
+func ensureZipJobsBucket(ctx context.Context, client *minio.Client, bucket string) error {
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return fmt.Errorf("failed to check zip jobs bucket: %w", err)
+	}
+	if exists {
+		return nil
+	}
+	if err := client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+		return fmt.Errorf("failed to create zip jobs bucket: %w", err)
+	}
+	log.Printf("✅ Created zip jobs bucket: %s", bucket)
+	return nil
+}
+
+// reusable reports whether a stored job can answer an identical request instead of a new build
+func (j ZipJob) reusable(ctx context.Context, minioClient *config.MinIOClients) bool {
+	switch j.Status {
+	case zipJobDone:
+		// a best-effort archive with missing files is built again, the files may be back
+		if j.Failed > 0 {
+			return false
+		}
+		// آرشیو ممکنه با lifecycle باکت پاک شده باشه
+		_, err := minioClient.Storage.Conn().StatObject(ctx, j.Bucket, j.ObjectKey, minio.StatObjectOptions{})
+		return err == nil
+	case zipJobQueued, zipJobRunning:
+		// A job that outlived its timeout died with its instance
+		return time.Since(j.CreatedAt) < getZipJobTimeout()
+	default:
+		return false
+	}
+}
+
+// snapshotZipJob returns a copy of an active job of this instance
+func snapshotZipJob(id string) (ZipJob, bool) {
+	activeZipJobsMu.Lock()
+	defer activeZipJobsMu.Unlock()
+
+	job, ok := activeZipJobs[id]
+	if !ok {
+		return ZipJob{}, false
+	}
+	return *job, true
+}
+
+// updateZipJob changes an active job under the lock and returns the result
+func updateZipJob(job *ZipJob, update func(job *ZipJob)) ZipJob {
+	activeZipJobsMu.Lock()
+	defer activeZipJobsMu.Unlock()
+
+	update(job)
+	job.UpdatedAt = time.Now()
+	return *job
+}
+
+func saveZipJob(store *record_store.Store, job ZipJob) {
+	putCtx, cancelPut := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelPut()
+
+	if err := store.Put(putCtx, zipJobKey(job.Id), job); err != nil {
+		log.Printf("⚠️ Failed to save zip job %s: %v", job.Id, err)
+	}
+}
+
+// loadZipJob returns a job of this instance, or the stored record of a job
+func loadZipJob(ctx context.Context, store *record_store.Store, id string) (ZipJob, error) {
+	if job, ok := snapshotZipJob(id); ok {
+		return job, nil
+	}
+
+	getCtx, cancelGet := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelGet()
+
+	var job ZipJob
+	if err := store.Get(getCtx, zipJobKey(id), &job); err != nil {
+		return ZipJob{}, err
+	}
+	return job, nil
+}
+
+// failedManifestEntries lists the files of a manifest that could not be added
+func failedManifestEntries(manifest *ZipManifest) []ZipManifestEntry {
+	var failures []ZipManifestEntry
+	for _, item := range manifest.Entries {
+		if item.Status == "failed" {
+			failures = append(failures, item)
+		}
+	}
+	return failures
+}
+
+// runZipJob builds the archive of a job and uploads it to the jobs bucket
+func runZipJob(minioClient *config.MinIOClients, store *record_store.Store, builder zipBuilder, job *ZipJob, entries []zipEntry) {
+	defer func() {
+		activeZipJobsMu.Lock()
+		delete(activeZipJobs, job.Id)
+		activeZipJobsMu.Unlock()
+	}()
+
+	finish := func(err error) {
+		now := time.Now()
+		snapshot := updateZipJob(job, func(job *ZipJob) {
+			job.FinishedAt = &now
+			if err != nil {
+				job.Status = zipJobFailed
+				job.Error = err.Error()
+				return
+			}
+			job.Status = zipJobDone
+		})
+		saveZipJob(store, snapshot)
+
+		if err != nil {
+			log.Printf("❌ Zip job %s failed: %v", job.Id, err)
+			return
+		}
+		log.Printf("✅ Zip job %s done: %d/%d files, %d bytes in %s/%s",
+			job.Id, snapshot.Succeeded, snapshot.Total, snapshot.Size, snapshot.Bucket, snapshot.ObjectKey)
+	}
+
+	jobCtx, cancelJob := context.WithTimeout(context.Background(), getZipJobTimeout())
+	defer cancelJob()
+
+	release, err := acquireZipJobSlot(jobCtx)
+	if err != nil {
+		finish(fmt.Errorf("job did not start in time: %w", err))
+		return
+	}
+	defer release()
+
+	saveZipJob(store, updateZipJob(job, func(job *ZipJob) { job.Status = zipJobRunning }))
+	log.Printf("🗜️ Zip job %s started (%d files, mode: %s)", job.Id, len(entries), job.Mode)
+
+	conn := minioClient.Storage.Conn()
+	if err := ensureZipJobsBucket(jobCtx, conn, job.Bucket); err != nil {
+		finish(err)
+		return
+	}
+
+	lastSaved := time.Now()
+	builder.progress = func(manifest *ZipManifest) {
+		snapshot := updateZipJob(job, func(job *ZipJob) {
+			job.FilesDone = manifest.Succeeded + manifest.Failed
+			job.Succeeded = manifest.Succeeded
+			job.Failed = manifest.Failed
+			job.Bytes = manifest.Bytes
+			job.Failures = failedManifestEntries(manifest)
+		})
+		if time.Since(lastSaved) >= zipJobSaveInterval {
+			lastSaved = time.Now()
+			saveZipJob(store, snapshot)
+		}
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	buildErr := make(chan error, 1)
+	go func() {
+		_, err := builder.build(jobCtx, pipeWriter, job.ObjectKey, entries)
+		_ = pipeWriter.CloseWithError(err)
+		buildErr <- err
+	}()
+
+	// اندازه آرشیو از قبل معلوم نیست، PartSize حافظه‌ی multipart رو محدود می‌کنه
+	info, uploadErr := conn.PutObject(jobCtx, job.Bucket, job.ObjectKey, pipeReader, -1, minio.PutObjectOptions{
+		ContentType: job.format().ContentType,
+		PartSize:    16 * 1024 * 1024,
+	})
+	// Unblock the builder if the upload stopped reading
+	_ = pipeReader.CloseWithError(uploadErr)
+	if err := <-buildErr; err != nil {
+		finish(err)
+		return
+	}
+	if uploadErr != nil {
+		finish(fmt.Errorf("failed to store archive: %w", uploadErr))
+		return
+	}
+
+	updateZipJob(job, func(job *ZipJob) { job.Size = info.Size })
+	finish(nil)
+}
+
+// zipJobError answers with a 404 for an unknown job, or a 500 when it cannot be read
+func zipJobError(ctx *fiber.Ctx, id string, err error) error {
+	if errors.Is(err, record_store.ErrNotFound) {
+		return ctx.Status(404).JSON(models.GenericResponse{
+			Result:  false,
+			Message: "Zip job not found",
+		})
+	}
+	log.Printf("❌ Failed to read zip job %s: %v", id, err)
+	return ctx.Status(500).JSON(models.GenericResponse{
+		Result:  false,
+		Message: "Failed to read zip job",
+	})
+}
+
+// zipJobId names the job of a request. Strict and best-effort builds of the same body
+// produce different archives (best-effort skips failed files), so they never share a job.
+func zipJobId(format archiveFormat, mode, requestHash string) string {
+	id := format.archiveId(requestHash)
+	if mode == zipModeStrict {
+		return id
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(mode+":"+id)))
+}
+
+// zipEntriesImmutable reports whether every entry is a Telegram file. A file_id always
+// names the same content; a bucket object or a link may have changed since the last build.
+func zipEntriesImmutable(entries []zipEntry) bool {
+	for _, entry := range entries {
+		if entry.Bucket != "" || entry.URL != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateZipJob starts building an archive in the background. The body is the same as
+// /zip/multi; an identical request returns the job that already exists for it.
+func CreateZipJob(ctx *fiber.Ctx) error {
+	entries, requestHash, err := parseZipRequest(ctx)
+	mode := zipModeStrict
+	if err == nil {
+		mode, err = parseZipMode(ctx)
+	}
+	format := archiveFormats[formatZip]
+	if err == nil {
+		format, err = parseArchiveFormat(ctx)
+	}
+	password, generatedPassword := "", false
+	if err == nil {
+		password, generatedPassword, err = parseZipPassword(ctx, format)
+	}
+	if err != nil {
+		return zipRequestError(ctx, err)
+	}
+
+	minioClient, err := getLocal[*config.MinIOClients](ctx, "minio")
+	if err != nil {
+		return err
+	}
+	botScopeConfig, err := getLocal[*config.BotScopeConfiguration](ctx, "BOT_SCOPE_CONFIG")
+	if err != nil {
+		return err
+	}
+	recordStore, err := getLocal[*record_store.Store](ctx, "RECORD_STORE")
+	if err != nil {
+		return err
+	}
+
+	id := zipJobId(format, mode, requestHash)
+	if password != "" || !zipEntriesImmutable(entries) {
+		// آرشیو رمزدار با رمز دیگه‌ای قابل استفاده نیست، و آبجکت یا لینک ممکنه از دفعه قبل عوض شده باشه،
+		// پس هر درخواست job خودش رو داره
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		id = fmt.Sprintf("%x", sha256.Sum256([]byte(id+":"+hex.EncodeToString(nonce))))
+	}
+	now := time.Now()
+	job := &ZipJob{
+		Id:        id,
+		Status:    zipJobQueued,
+		Mode:      mode,
+		Format:    format.Name,
+		Bucket:    getZipJobsBucket(),
+		ObjectKey: format.fileName(id),
+		Encrypted: password != "",
+		Total:     len(entries),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	// جای job رو قبل از خوندن رکورد رزرو کن تا دو درخواست همزمان دو بار نسازن
+	activeZipJobsMu.Lock()
+	if active, ok := activeZipJobs[id]; ok {
+		snapshot := *active
+		activeZipJobsMu.Unlock()
+		log.Printf("♻️ Zip job %s already in progress", id)
+		return ctx.Status(200).JSON(fiber.Map{"result": true, "deduplicated": true, "job": snapshot})
+	}
+	activeZipJobs[id] = job
+	activeZipJobsMu.Unlock()
+
+	getCtx, cancelGet := context.WithTimeout(ctx.UserContext(), 10*time.Second)
+	defer cancelGet()
+	var existing ZipJob
+	if err := recordStore.Get(getCtx, zipJobKey(id), &existing); err == nil && existing.reusable(getCtx, minioClient) {
+		activeZipJobsMu.Lock()
+		delete(activeZipJobs, id)
+		activeZipJobsMu.Unlock()
+
+		log.Printf("♻️ Reusing zip job %s (%s)", id, existing.Status)
+		return ctx.Status(200).JSON(fiber.Map{"result": true, "deduplicated": true, "job": existing})
+	}
+
+	saveZipJob(recordStore, *job)
+
+	builder := zipBuilder{
+		endpoint:       "/zip/jobs",
+		minioClient:    minioClient,
+		botScopeConfig: botScopeConfig,
+		recordStore:    recordStore,
+		// Same limits as /zip/multi/optimized
+		opts: optimizedZipOptions(),
+	}
+	builder.opts.Mode = mode
+	builder.opts.Format = format
+	builder.opts.Password = password
+	snapshot := *job
+	go runZipJob(minioClient, recordStore, builder, job, entries)
+
+	if generatedPassword {
+		ctx.Set(zipPasswordHeader, password)
+	}
+	log.Printf("🗜️ Zip job %s queued (%d files)", id, len(entries))
+	return ctx.Status(202).JSON(fiber.Map{"result": true, "deduplicated": false, "job": snapshot})
+}
+
+// GetZipJob returns the progress of a zip job
+func GetZipJob(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	if !zipJobIdPattern.MatchString(id) {
+		return ctx.Status(400).JSON(models.GenericResponse{
+			Result:  false,
+			Message: "Invalid zip job id",
+		})
+	}
+
+	recordStore, err := getLocal[*record_store.Store](ctx, "RECORD_STORE")
+	if err != nil {
+		return err
+	}
+
+	job, err := loadZipJob(ctx.UserContext(), recordStore, id)
+	if err != nil {
+		return zipJobError(ctx, id, err)
+	}
+	return ctx.Status(200).JSON(fiber.Map{"result": true, "job": job})
+}
+
+// DownloadZipJob streams the archive of a finished job, or returns a presigned link with ?presign=true
+func DownloadZipJob(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	if !zipJobIdPattern.MatchString(id) {
+		return ctx.Status(400).JSON(models.GenericResponse{
+			Result:  false,
+			Message: "Invalid zip job id",
+		})
+	}
+
+	minioClient, err := getLocal[*config.MinIOClients](ctx, "minio")
+	if err != nil {
+		return err
+	}
+	recordStore, err := getLocal[*record_store.Store](ctx, "RECORD_STORE")
+	if err != nil {
+		return err
+	}
+
+	job, err := loadZipJob(ctx.UserContext(), recordStore, id)
+	if err != nil {
+		return zipJobError(ctx, id, err)
+	}
+	if job.Status != zipJobDone {
+		return ctx.Status(409).JSON(fiber.Map{
+			"result":  false,
+			"message": "Zip job is " + job.Status,
+			"job":     job,
+		})
+	}
+
+	conn := minioClient.Storage.Conn()
+	if ctx.QueryBool("presign") {
+		ttl := getZipJobLinkTTL()
+		params := url.Values{}
+		params.Set("response-content-disposition", "attachment; filename="+job.ObjectKey)
+
+		link, err := conn.PresignedGetObject(ctx.UserContext(), job.Bucket, job.ObjectKey, ttl, params)
+		if err != nil {
+			log.Printf("❌ Failed to presign zip job %s: %v", id, err)
+			return ctx.Status(500).JSON(models.GenericResponse{
+				Result:  false,
+				Message: "Failed to create download link",
+			})
+		}
+		return ctx.Status(200).JSON(fiber.Map{
+			"result":    true,
+			"url":       link.String(),
+			"expiresAt": time.Now().Add(ttl),
+		})
+	}
+
+	// context درخواست بعد از return تموم میشه ولی استریم بعدش خونده میشه
+	object, err := conn.GetObject(context.Background(), job.Bucket, job.ObjectKey, minio.GetObjectOptions{})
+	if err == nil {
+		var info minio.ObjectInfo
+		if info, err = object.Stat(); err == nil {
+			ctx.Set("Content-Type", job.format().ContentType)
+			ctx.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", job.ObjectKey))
+			ctx.Set("X-Zip-Mode", job.Mode)
+			ctx.Context().SetBodyStream(object, int(info.Size))
+			return nil
+		}
+		_ = object.Close()
+	}
+
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ctx.Status(410).JSON(models.GenericResponse{
+			Result:  false,
+			Message: "Zip job archive no longer exists",
+		})
+	}
+	log.Printf("❌ Failed to open zip job archive %s: %v", id, err)
+	return ctx.Status(500).JSON(models.GenericResponse{
+		Result:  false,
+		Message: "Failed to read zip job archive",
+	})
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestZipJobId(t *testing.T) {
+	const hash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	zipFormat, tarFormat := archiveFormats[formatZip], archiveFormats[formatTar]
+
+	ids := map[string]string{
+		"zip strict":      zipJobId(zipFormat, zipModeStrict, hash),
+		"zip best-effort": zipJobId(zipFormat, zipModeBestEffort, hash),
+		"tar strict":      zipJobId(tarFormat, zipModeStrict, hash),
+		"tar best-effort": zipJobId(tarFormat, zipModeBestEffort, hash),
+	}
+	if ids["zip strict"] != hash {
+		t.Errorf("strict zip job id = %s, want the request hash", ids["zip strict"])
+	}
+
+	seen := map[string]string{}
+	for name, id := range ids {
+		if !zipJobIdPattern.MatchString(id) {
+			t.Errorf("%s job id %q is not a valid job id", name, id)
+		}
+		if other, ok := seen[id]; ok {
+			t.Errorf("%s and %s share the job id %s", name, other, id)
+		}
+		seen[id] = name
+	}
+}
+
+func TestZipEntriesImmutable(t *testing.T) {
+	telegram := zipEntry{BotName: "telegram", FileId: "F1"}
+	object := zipEntry{Bucket: "uploads", Key: "a.jpg"}
+	link := zipEntry{URL: "https://example.com/a.jpg"}
+
+	tests := []struct {
+		name    string
+		entries []zipEntry
+		want    bool
+	}{
+		{"telegram files only", []zipEntry{telegram, telegram}, true},
+		{"a bucket object", []zipEntry{telegram, object}, false},
+		{"a link", []zipEntry{link, telegram}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := zipEntriesImmutable(tt.entries); got != tt.want {
+				t.Errorf("zipEntriesImmutable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZipJobReusable(t *testing.T) {
+	// none of these cases reach MinIO, so no client is needed
+	tests := []struct {
+		name string
+		job  ZipJob
+		want bool
+	}{
+		{"queued", ZipJob{Status: zipJobQueued, CreatedAt: time.Now()}, true},
+		{"running past the timeout", ZipJob{Status: zipJobRunning, CreatedAt: time.Now().Add(-getZipJobTimeout())}, false},
+		{"failed", ZipJob{Status: zipJobFailed}, false},
+		{"done with failed files", ZipJob{Status: zipJobDone, Succeeded: 2, Failed: 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.job.reusable(context.Background(), nil); got != tt.want {
+				t.Errorf("reusable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
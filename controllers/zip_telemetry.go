@@ -0,0 +1,276 @@
+package controllers
+
+import (
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// zipFileSample is the outcome of one file of an instrumented archive
+type zipFileSample struct {
+	scope     string
+	bytes     int64
+	latencyMs float64
+	source    string
+	bot       string
+	failed    bool
+}
+
+// ZipTelemetryRecord is one instrumented archive build
+type ZipTelemetryRecord struct {
+	Archive        string    `json:"archive"`
+	Endpoint       string    `json:"endpoint"`
+	Mode           string    `json:"mode"`
+	Format         string    `json:"format,omitempty"`
+	Scopes         []string  `json:"scopes"`
+	StartedAt      time.Time `json:"startedAt"`
+	DurationMs     float64   `json:"durationMs"`
+	Files          int       `json:"files"`
+	Succeeded      int       `json:"succeeded"`
+	Failed         int       `json:"failed"`
+	Bytes          int64     `json:"bytes"`
+	ThroughputMBps float64   `json:"throughputMBps"`
+	ConcurrentMax  int       `json:"concurrentMax"`
+	Error          string    `json:"error,omitempty"`
+	// samples are kept for the aggregates, not listed per record
+	samples []zipFileSample
+}
+
+// ZipPercentiles summarises a set of samples
+type ZipPercentiles struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// ZipPerformanceStats aggregates the archives in the telemetry window
+type ZipPerformanceStats struct {
+	From            *time.Time                `json:"from,omitempty"`
+	To              *time.Time                `json:"to,omitempty"`
+	Jobs            int                       `json:"jobs"`
+	FailedJobs      int                       `json:"failed_jobs"`
+	TotalFiles      int                       `json:"total_files"`
+	FilesProcessed  int                       `json:"files_processed"`
+	FailedFiles     int                       `json:"failed_files"`
+	CacheHits       int                       `json:"cache_hits"`
+	TotalSize       int64                     `json:"total_size"`
+	AverageFileSize int64                     `json:"average_file_size"`
+	ThroughputMBps  float64                   `json:"throughput_mbps"`
+	SuccessRate     float64                   `json:"success_rate"`
+	JobDurationMs   ZipPercentiles            `json:"job_duration_ms"`
+	FileLatencyMs   ZipPercentiles            `json:"file_latency_ms"`
+	BotWins         map[string]map[string]int `json:"bot_wins"`
+}
+
+// zipTelemetryFilter selects records by start time and scope
+type zipTelemetryFilter struct {
+	From  time.Time
+	To    time.Time
+	Scope string
+}
+
+func (f zipTelemetryFilter) matches(record ZipTelemetryRecord) bool {
+	if !f.From.IsZero() && record.StartedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && record.StartedAt.After(f.To) {
+		return false
+	}
+	return f.Scope == "" || slices.Contains(record.Scopes, f.Scope)
+}
+
+// zipTelemetryWindow keeps the most recent archive builds in a ring
+type zipTelemetryWindow struct {
+	mu      sync.Mutex
+	records []ZipTelemetryRecord
+	next    int
+	full    bool
+}
+
+var (
+	zipTelemetry     *zipTelemetryWindow
+	zipTelemetryOnce sync.Once
+)
+
+// getZipTelemetry returns the window sized by ZIP_TELEMETRY_WINDOW (default 500 archives)
+func getZipTelemetry() *zipTelemetryWindow {
+	zipTelemetryOnce.Do(func() {
+		size := 500
+		if v := os.Getenv("ZIP_TELEMETRY_WINDOW"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				size = n
+			}
+		}
+		zipTelemetry = &zipTelemetryWindow{records: make([]ZipTelemetryRecord, size)}
+	})
+	return zipTelemetry
+}
+
+func (w *zipTelemetryWindow) add(record ZipTelemetryRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.records[w.next] = record
+	w.next = (w.next + 1) % len(w.records)
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+// list returns the matching records, newest first
+func (w *zipTelemetryWindow) list(filter zipTelemetryFilter) []ZipTelemetryRecord {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	count := w.next
+	if w.full {
+		count = len(w.records)
+	}
+
+	records := make([]ZipTelemetryRecord, 0, count)
+	for i := 1; i <= count; i++ {
+		record := w.records[(w.next-i+len(w.records))%len(w.records)]
+		if filter.matches(record) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// zipWinningBot returns the bot that delivered the data from a servedBy description
+func zipWinningBot(servedBy string) string {
+	// "GetFile:a|Download:b" یعنی دانلود با بات b انجام شده
+	if _, download, found := strings.Cut(servedBy, "|Download:"); found {
+		return download
+	}
+	return servedBy
+}
+
+// recordZipTelemetry adds a finished or aborted build to the telemetry window
+func recordZipTelemetry(b zipBuilder, manifest *ZipManifest, duration time.Duration, err error) {
+	if manifest == nil {
+		return
+	}
+
+	record := ZipTelemetryRecord{
+		Archive:       manifest.Archive,
+		Endpoint:      b.endpoint,
+		Mode:          manifest.Mode,
+		Format:        b.opts.Format.Name,
+		StartedAt:     manifest.CreatedAt,
+		DurationMs:    float64(duration) / float64(time.Millisecond),
+		Files:         manifest.Total,
+		Succeeded:     manifest.Succeeded,
+		Failed:        manifest.Failed,
+		Bytes:         manifest.Bytes,
+		ConcurrentMax: b.opts.MaxConcurrent,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if seconds := duration.Seconds(); seconds > 0 {
+		record.ThroughputMBps = float64(manifest.Bytes) / 1024 / 1024 / seconds
+	}
+
+	for _, item := range manifest.Entries {
+		scope := strings.ToLower(item.BotName)
+		// آبجکت‌های باکت و لینک‌ها scope ندارن
+		if scope != "" && !slices.Contains(record.Scopes, scope) {
+			record.Scopes = append(record.Scopes, scope)
+		}
+		// فایل‌هایی که بعد از abort هنوز pending بودن نمونه حساب نمیشن
+		if item.Status == "pending" {
+			continue
+		}
+		record.samples = append(record.samples, zipFileSample{
+			scope:     scope,
+			bytes:     item.Size,
+			latencyMs: float64(item.DurationMs),
+			source:    item.Source,
+			bot:       zipWinningBot(item.ServedBy),
+			failed:    item.Status == "failed",
+		})
+	}
+
+	getZipTelemetry().add(record)
+	log.Printf("📈 Zip %s: %d/%d files, %d bytes in %.0fms (%.2f MB/s)",
+		record.Archive, record.Succeeded, record.Files, record.Bytes, record.DurationMs, record.ThroughputMBps)
+}
+
+// zipPercentiles uses the same nearest-rank method as the bot latency percentiles
+func zipPercentiles(samples []float64) ZipPercentiles {
+	if len(samples) == 0 {
+		return ZipPercentiles{}
+	}
+	sorted := slices.Clone(samples)
+	sort.Float64s(sorted)
+
+	at := func(p float64) float64 {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	return ZipPercentiles{P50: at(0.50), P95: at(0.95), P99: at(0.99), Max: sorted[len(sorted)-1]}
+}
+
+// summarizeZipTelemetry aggregates records; with a scope only that scope's files are counted
+func summarizeZipTelemetry(records []ZipTelemetryRecord, filter zipTelemetryFilter) ZipPerformanceStats {
+	stats := ZipPerformanceStats{BotWins: map[string]map[string]int{}}
+	if !filter.From.IsZero() {
+		stats.From = &filter.From
+	}
+	if !filter.To.IsZero() {
+		stats.To = &filter.To
+	}
+
+	var jobDurations, fileLatencies []float64
+	var totalDurationMs float64
+	for _, record := range records {
+		stats.Jobs++
+		if record.Error != "" {
+			stats.FailedJobs++
+		}
+		jobDurations = append(jobDurations, record.DurationMs)
+		totalDurationMs += record.DurationMs
+
+		for _, sample := range record.samples {
+			if filter.Scope != "" && sample.scope != filter.Scope {
+				continue
+			}
+			stats.TotalFiles++
+			fileLatencies = append(fileLatencies, sample.latencyMs)
+			if sample.failed {
+				stats.FailedFiles++
+				continue
+			}
+
+			stats.FilesProcessed++
+			stats.TotalSize += sample.bytes
+			if sample.source == zipSourceCache {
+				stats.CacheHits++
+			} else if sample.bot != "" {
+				if stats.BotWins[sample.scope] == nil {
+					stats.BotWins[sample.scope] = map[string]int{}
+				}
+				stats.BotWins[sample.scope][sample.bot]++
+			}
+		}
+	}
+
+	if stats.FilesProcessed > 0 {
+		stats.AverageFileSize = stats.TotalSize / int64(stats.FilesProcessed)
+	}
+	if stats.TotalFiles > 0 {
+		stats.SuccessRate = float64(stats.FilesProcessed) / float64(stats.TotalFiles)
+	}
+	if totalDurationMs > 0 {
+		stats.ThroughputMBps = float64(stats.TotalSize) / 1024 / 1024 / (totalDurationMs / 1000)
+	}
+	stats.JobDurationMs = zipPercentiles(jobDurations)
+	stats.FileLatencyMs = zipPercentiles(fileLatencies)
+	return stats
+}
@@ -6,6 +6,7 @@ import (
 	"go-uploader/controllers"
 	"go-uploader/middleware"
 	"go-uploader/pkg/instagram_api"
+	"go-uploader/pkg/record_store"
 	"go-uploader/utils"
 	"log"
 	"os"
@@ -57,32 +58,35 @@ func main() {
 		log.Fatal("Cannot start without required configuration")
 	}
 
-	// Optional environment variables - set defaults if not provided
+	// Optional environment variables - scopes without a destination chat reject uploads
 	if os.Getenv("DEST_CHAT_ID") == "" {
-		log.Printf("⚠️ DEST_CHAT_ID not set, using default")
-		os.Setenv("DEST_CHAT_ID", "-1001234567890")
+		log.Printf("⚠️ DEST_CHAT_ID not set, uploads need a per-scope destination chat")
 	}
 
-	// Check for bot tokens - at least one should be present
-	botTokens := []string{
-		"BOT_TELEGRAM",
-		"BOT_INSTAGRAM",
-		"BOT_TRACKER",
-		"BOT_INFLUENCER",
-	}
+	// Check for bot tokens - at least one should be present (unless scopes come from a file)
+	if scopesFile := config.BotScopesFile(); scopesFile != "" {
+		log.Printf("✅ Bot scopes will be loaded from %s", scopesFile)
+	} else {
+		botTokens := []string{
+			"BOT_TELEGRAM",
+			"BOT_INSTAGRAM",
+			"BOT_TRACKER",
+			"BOT_INFLUENCER",
+		}
 
-	hasAnyBot := false
-	for _, botEnv := range botTokens {
-		if os.Getenv(botEnv) != "" {
-			hasAnyBot = true
-			log.Printf("✅ Found %s configuration", botEnv)
-		} else {
-			log.Printf("⚠️ %s not configured, scope will be empty", botEnv)
+		hasAnyBot := false
+		for _, botEnv := range botTokens {
+			if os.Getenv(botEnv) != "" {
+				hasAnyBot = true
+				log.Printf("✅ Found %s configuration", botEnv)
+			} else {
+				log.Printf("⚠️ %s not configured, scope will be empty", botEnv)
+			}
 		}
-	}
 
-	if !hasAnyBot {
-		log.Printf("⚠️ Warning: No bot tokens configured, most features will not work!")
+		if !hasAnyBot {
+			log.Printf("⚠️ Warning: No bot tokens configured, most features will not work!")
+		}
 	}
 
 	// Log MAX_RACING_BOTS configuration
@@ -97,12 +101,25 @@ func main() {
 	minioClients := config.GetMinIOClients(minioConfig)
 	log.Printf("✅ MinIO client initialized")
 
+	// Initialize record store for upload bookkeeping
+	recordStore := record_store.New(minioClients.Storage.Conn(), record_store.BucketFromEnv())
+	bucketCtx, cancelBucket := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := recordStore.EnsureBucket(bucketCtx); err != nil {
+		log.Printf("⚠️ Record store unavailable: %v", err)
+	} else {
+		log.Printf("✅ Record store ready: %s", recordStore.Bucket())
+	}
+	cancelBucket()
+
 	// Initialize Snitch configuration (optional)
 	snitchConfiguration := config.NewSnitchConfiguration()
 	log.Printf("✅ Snitch configuration loaded")
 
 	// Initialize bot scope configuration
-	botScopeConfig := config.NewBotScopeConfiguration()
+	botScopeConfig, err := config.LoadBotScopes()
+	if err != nil {
+		log.Fatalf("❌ Failed to load bot scopes: %v", err)
+	}
 	allScopes := botScopeConfig.GetAllScopes()
 	if len(allScopes) > 0 {
 		log.Printf("✅ Bot scopes initialized: %v", allScopes)
@@ -110,6 +127,33 @@ func main() {
 		log.Printf("⚠️ No bot scopes available")
 	}
 
+	// Validate bot tokens with getMe (optional fail fast)
+	if config.ShouldValidateBotsOnStartup() {
+		validateCtx, cancelValidate := context.WithTimeout(context.Background(), 30*time.Second)
+		validateErrs := botScopeConfig.ValidateBots(validateCtx)
+		cancelValidate()
+
+		for _, validateErr := range validateErrs {
+			log.Printf("⚠️ Bot validation: %v", validateErr)
+		}
+		if len(validateErrs) > 0 && config.ShouldFailFastOnInvalidBots() {
+			log.Fatalf("❌ %d bot(s) failed validation and BOT_VALIDATE_FAIL_FAST is set", len(validateErrs))
+		}
+		if len(validateErrs) == 0 {
+			log.Printf("✅ All bot tokens verified")
+		}
+	}
+
+	// Background workers stop when the server shuts down
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	// Delete carrier messages from DEST_CHAT_ID after UPLOAD_RETENTION (optional)
+	controllers.StartCarrierCleanup(workersCtx, recordStore)
+
+	// Reload BOT_SCOPES_FILE on SIGHUP or when it changes (optional)
+	go config.WatchBotScopes(workersCtx)
+
 	// Initialize Instagram API (optional)
 	instagramApi := instagram_api.New(os.Getenv("INSTAGRAM_API"))
 	if os.Getenv("INSTAGRAM_API") != "" {
@@ -154,8 +198,8 @@ func main() {
 			log.Printf("⚠️ CORS_ALLOWED_ORIGINS not set, defaulting to localhost only")
 			return "http://localhost:3000"
 		}(),
-		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders: "Origin,Content-Type,Accept,Authorization",
+		AllowMethods: "GET,POST,PUT,PATCH,DELETE,OPTIONS",
+		AllowHeaders: "Origin,Content-Type,Accept,Authorization,X-Admin-Key",
 	}))
 
 	// Use moderate compression for better performance balance
@@ -176,9 +220,11 @@ func main() {
 	// Attach other configurations
 	app.Use(func(ctx *fiber.Ctx) error {
 		// Set the bot scope configuration - contains all bot arrays in hashmap
-		ctx.Locals("BOT_SCOPE_CONFIG", botScopeConfig)
+		// Each request keeps the snapshot it started with, even across a reload
+		ctx.Locals("BOT_SCOPE_CONFIG", config.CurrentBotScopes())
 		ctx.Locals("INSTAGRAM_API", instagramApi)
 		ctx.Locals("SNITCH_CONFIG", snitchConfiguration)
+		ctx.Locals("RECORD_STORE", recordStore)
 		return ctx.Next()
 	})
 
@@ -205,7 +251,7 @@ func main() {
 		}
 
 		// Check bot scopes
-		scopes := botScopeConfig.GetAllScopes()
+		scopes := config.CurrentBotScopes().GetAllScopes()
 		health["bot_scopes"] = len(scopes)
 
 		return c.JSON(health)
@@ -226,9 +272,17 @@ func main() {
 	app.Post("/zip/multi/optimized", JWTMiddleware, controllers.ZipMultipleFilesOptimized)
 	// Performance monitoring endpoint
 	app.Get("/zip/performance", JWTMiddleware, controllers.GetZipPerformanceInfo)
+	// Background archives stored in ZIP_JOBS_BUCKET
+	app.Post("/zip/jobs", JWTMiddleware, controllers.CreateZipJob)
+	app.Get("/zip/jobs/:id", JWTMiddleware, controllers.GetZipJob)
+	app.Get("/zip/jobs/:id/download", JWTMiddleware, controllers.DownloadZipJob)
+	// Explode an uploaded archive into a bucket or a scope's bots
+	app.Post("/zip/ingest", uploadLimiter, JWTMiddleware, controllers.IngestArchive)
 
 	// Telegram upload operations
 	app.Post("/upload/telegram/link/:botName", uploadLimiter, JWTMiddleware, controllers.UploadToTelegramViaLink)
+	app.Post("/upload/telegram/album/:botName", uploadLimiter, JWTMiddleware, controllers.UploadAlbumToTelegram)
+	app.Post("/upload/telegram/album/:botName/:specificBot", uploadLimiter, JWTMiddleware, controllers.UploadAlbumToTelegram)
 	app.Post("/upload/telegram/:botName", uploadLimiter, JWTMiddleware, controllers.UploadToTelegram)
 	app.Post("/upload/telegram/:botName/:specificBot", uploadLimiter, JWTMiddleware, controllers.UploadToTelegram)
 
@@ -243,12 +297,24 @@ func main() {
 	app.Get("/instant/:botName/:fileId", uploadLimiter, controllers.DownloadFromTelegram)
 	app.Get("/instant/:botName/:fileId/:specificBot", uploadLimiter, controllers.DownloadFromTelegram)
 
+	// Telegram webhook (verified by secret token header, not JWT)
+	app.Post("/telegram/webhook/:scope", controllers.TelegramWebhook)
+	app.Get("/telegram/webhook/:scope/records", JWTMiddleware, controllers.ListWebhookRecords)
+
 	// Direct storage operations
 	app.Post("/direct/:bucketName", JWTMiddleware, controllers.UploadFile)
 	app.Get("/direct/*", JWTMiddleware, controllers.DownloadFile)
 
 	// Bot scope management
 	app.Get("/bot-scopes", JWTMiddleware, controllers.ListBotScopes)
+	app.Get("/bot-scopes/stats", JWTMiddleware, controllers.BotScopeStats)
+	app.Post("/bot-scopes/refresh", JWTMiddleware, middleware.AdminOnly, controllers.RefreshBotScopes)
+	app.Post("/bot-scopes/scopes", JWTMiddleware, middleware.AdminOnly, controllers.CreateBotScope)
+	app.Patch("/bot-scopes/scopes/:scope", JWTMiddleware, middleware.AdminOnly, controllers.UpdateBotScope)
+	app.Delete("/bot-scopes/scopes/:scope", JWTMiddleware, middleware.AdminOnly, controllers.DeleteBotScope)
+	app.Post("/bot-scopes/scopes/:scope/bots", JWTMiddleware, middleware.AdminOnly, controllers.AddScopeBot)
+	app.Patch("/bot-scopes/scopes/:scope/bots/:bot", JWTMiddleware, middleware.AdminOnly, controllers.UpdateScopeBot)
+	app.Delete("/bot-scopes/scopes/:scope/bots/:bot", JWTMiddleware, middleware.AdminOnly, controllers.DeleteScopeBot)
 
 	// 404 handler
 	app.Use(func(c *fiber.Ctx) error {
@@ -283,6 +349,7 @@ func main() {
 
 	<-quit
 	log.Println("Shutting down server...")
+	stopWorkers()
 
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
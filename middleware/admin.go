@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+	"go-uploader/models"
+)
+
+// AdminOnly allows the request only when X-Admin-Key matches ADMIN_API_KEY
+func AdminOnly(c *fiber.Ctx) error {
+	adminKey := os.Getenv("ADMIN_API_KEY")
+	if adminKey == "" {
+		return c.Status(403).JSON(models.GenericResponse{
+			Result:  false,
+			Message: "admin API is disabled",
+		})
+	}
+
+	provided := c.Get("X-Admin-Key")
+	if subtle.ConstantTimeCompare([]byte(provided), []byte(adminKey)) != 1 {
+		return c.Status(403).JSON(models.GenericResponse{
+			Result:  false,
+			Message: "admin access required",
+		})
+	}
+
+	return c.Next()
+}
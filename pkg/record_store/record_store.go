@@ -0,0 +1,152 @@
+package record_store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const maxRecordSize = 1 * 1024 * 1024 // 1 MB per JSON record
+
+// ErrNotFound is returned when a record key does not exist
+var ErrNotFound = errors.New("record not found")
+
+// Store keeps small JSON records as objects in a MinIO bucket
+type Store struct {
+	client *minio.Client
+	bucket string
+}
+
+// BucketFromEnv returns the records bucket name from RECORDS_BUCKET
+func BucketFromEnv() string {
+	if bucket := os.Getenv("RECORDS_BUCKET"); bucket != "" {
+		return bucket
+	}
+	return "middleware-records"
+}
+
+func New(client *minio.Client, bucket string) *Store {
+	if client == nil {
+		log.Printf("⚠️ RecordStore created without MinIO client")
+	}
+
+	return &Store{
+		client: client,
+		bucket: bucket,
+	}
+}
+
+// Bucket returns the bucket the records are kept in
+func (s *Store) Bucket() string {
+	return s.bucket
+}
+
+// EnsureBucket creates the records bucket if it does not exist yet
+func (s *Store) EnsureBucket(ctx context.Context) error {
+	if s.client == nil {
+		return errors.New("record store has no MinIO client")
+	}
+
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return fmt.Errorf("failed to check records bucket: %w", err)
+	}
+	if exists {
+		return nil
+	}
+
+	if err := s.client.MakeBucket(ctx, s.bucket, minio.MakeBucketOptions{}); err != nil {
+		return fmt.Errorf("failed to create records bucket: %w", err)
+	}
+
+	log.Printf("✅ Created records bucket: %s", s.bucket)
+	return nil
+}
+
+// Put stores value as JSON under key
+func (s *Store) Put(ctx context.Context, key string, value interface{}) error {
+	if s.client == nil {
+		return errors.New("record store has no MinIO client")
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode record %s: %w", key, err)
+	}
+
+	_, err = s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put record %s: %w", key, err)
+	}
+	return nil
+}
+
+// Get decodes the JSON record under key into value
+func (s *Store) Get(ctx context.Context, key string, value interface{}) error {
+	if s.client == nil {
+		return errors.New("record store has no MinIO client")
+	}
+
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get record %s: %w", key, err)
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(io.LimitReader(object, maxRecordSize))
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to read record %s: %w", key, err)
+	}
+
+	if err := json.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("failed to decode record %s: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes the record under key
+func (s *Store) Delete(ctx context.Context, key string) error {
+	if s.client == nil {
+		return errors.New("record store has no MinIO client")
+	}
+
+	if err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete record %s: %w", key, err)
+	}
+	return nil
+}
+
+// List returns every record key that starts with prefix
+func (s *Store) List(ctx context.Context, prefix string) ([]string, error) {
+	if s.client == nil {
+		return nil, errors.New("record store has no MinIO client")
+	}
+
+	var keys []string
+	for info := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if info.Err != nil {
+			return nil, fmt.Errorf("failed to list records %s: %w", prefix, info.Err)
+		}
+		if strings.HasSuffix(info.Key, "/") {
+			continue
+		}
+		keys = append(keys, info.Key)
+	}
+	return keys, nil
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"log"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -59,11 +60,28 @@ func (h *TelegramAPI) String() string {
 	return "TelegramAPI{token: ***}"
 }
 
+// HasToken reports whether the client uses the given token
+func (h *TelegramAPI) HasToken(token string) bool {
+	return h != nil && h.token == token
+}
+
 // redactURL removes the bot token from URLs for safe logging
 func (h *TelegramAPI) redactURL(url string) string {
 	return strings.Replace(url, h.token, "***", -1)
 }
 
+// requestError describes a request that got no response without the bot token: a
+// *url.Error carries the full URL. A cancelled ctx and ErrNotSent stay matchable.
+func (h *TelegramAPI) requestError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("request failed: %w", ctx.Err())
+	}
+	if IsNotSent(err) {
+		return fmt.Errorf("request failed: %s: %w", h.redactURL(err.Error()), ErrNotSent)
+	}
+	return fmt.Errorf("request failed: %s", h.redactURL(err.Error()))
+}
+
 type GetFileResponse struct {
 	Ok     bool   `json:"ok"`
 	Result struct {
@@ -133,7 +151,7 @@ func (h *TelegramAPI) DownloadFile(filePath string) ([]byte, string, error) {
 	// اولین تلاش
 	response, err := h.client.Get(reqURL)
 	if err != nil {
-		return nil, "", fmt.Errorf("request failed: %w", err)
+		return nil, "", h.requestError(context.Background(), err)
 	}
 
 	defer response.Body.Close()
@@ -328,18 +346,22 @@ func (h *TelegramAPI) Explode(filePath interface{}) string {
 	return filePathStr
 }
 
-func (h *TelegramAPI) UploadFile(contentType string, fileName string, data []byte, chatId string) (string, error) {
-	// تعیین نوع فیلد بر اساس content type
-	var formField string
+// mediaKind returns the Bot API field used to send a file of the given content type
+func mediaKind(contentType string) string {
 	if strings.Contains(contentType, "image") {
-		formField = "photo"
+		return "photo"
 	} else if strings.Contains(contentType, "audio") {
-		formField = "audio"
+		return "audio"
 	} else if strings.Contains(contentType, "video") {
-		formField = "video"
-	} else {
-		formField = "document"
+		return "video"
 	}
+	return "document"
+}
+
+// UploadFile sends data to chatId and returns the ids of the created message and file
+func (h *TelegramAPI) UploadFile(contentType string, fileName string, data []byte, chatId string) (*UploadResult, error) {
+	// تعیین نوع فیلد بر اساس content type
+	formField := mediaKind(contentType)
 
 	// آماده‌سازی request body
 	body := &bytes.Buffer{}
@@ -360,28 +382,28 @@ func (h *TelegramAPI) UploadFile(contentType string, fileName string, data []byt
 
 	// نوشتن chat_id
 	if err := mwriter.WriteField("chat_id", chatId); err != nil {
-		return "", fmt.Errorf("failed to write chat_id: %w", err)
+		return nil, fmt.Errorf("failed to write chat_id: %w", err)
 	}
 
 	// ایجاد فیلد فایل
 	fileWriter, err := mwriter.CreateFormFile(formField, fileName)
 	if err != nil {
-		return "", fmt.Errorf("failed to create form file: %w", err)
+		return nil, fmt.Errorf("failed to create form file: %w", err)
 	}
 
 	if _, err := fileWriter.Write(data); err != nil {
-		return "", fmt.Errorf("failed to write file data: %w", err)
+		return nil, fmt.Errorf("failed to write file data: %w", err)
 	}
 
 	// بستن multipart writer
 	if err := mwriter.Close(); err != nil {
-		return "", fmt.Errorf("failed to close multipart writer: %w", err)
+		return nil, fmt.Errorf("failed to close multipart writer: %w", err)
 	}
 
 	// ایجاد HTTP request
 	req, err := http.NewRequest("POST", reqUrl, body)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", mwriter.FormDataContentType())
@@ -389,140 +411,646 @@ func (h *TelegramAPI) UploadFile(contentType string, fileName string, data []byt
 	// ارسال request
 	response, err := h.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("request failed: %w", err)
+		return nil, h.requestError(context.Background(), err)
 	}
 
 	defer response.Body.Close()
 	resBody, err := io.ReadAll(io.LimitReader(response.Body, maxAPIResponseSize))
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	if response.StatusCode != 200 {
-		return "", fmt.Errorf("telegram upload failed (status %d): %s", response.StatusCode, string(resBody))
+		return nil, parseAPIError(reqUrl[strings.LastIndex(reqUrl, "/")+1:], response.StatusCode, resBody)
 	}
 
 	// پردازش JSON response
 	var tgResponse map[string]interface{}
 	if err := json.Unmarshal(resBody, &tgResponse); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
+		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
 
 	// چک کردن نتیجه
 	ok, _ := tgResponse["ok"].(bool)
 	if !ok {
-		description, _ := tgResponse["description"].(string)
-		return "", fmt.Errorf("telegram API error: %s", description)
+		return nil, parseAPIError(reqUrl[strings.LastIndex(reqUrl, "/")+1:], response.StatusCode, resBody)
 	}
 
 	// استخراج file_id
 	result, ok := tgResponse["result"].(map[string]interface{})
 	if !ok {
-		return "", errors.New("invalid response format: missing result")
+		return nil, errors.New("invalid response format: missing result")
 	}
 
-	var fileID string
-	if formField == "document" || formField == "video" || formField == "audio" {
-		fileInfo, ok := result[formField].(map[string]interface{})
-		if !ok {
-			return "", fmt.Errorf("missing %s in response", formField)
+	uploaded, err := parseUploadedMessage(result, formField)
+	if err != nil {
+		return nil, err
+	}
+	if uploaded.MimeType == "" {
+		uploaded.MimeType = contentType
+	}
+
+	log.Printf("📤 Upload successful: %s (FileID: %s, MessageID: %d)", fileName, uploaded.FileId, uploaded.MessageId)
+	return uploaded, nil
+}
+
+// متدهای با Context support
+func (h *TelegramAPI) GetFileWithContext(ctx context.Context, fileId string) (string, error) {
+	info, err := h.GetFileInfo(ctx, fileId)
+	if err != nil {
+		return "", err
+	}
+	return info.FilePath, nil
+}
+
+// FileInfo is the File object returned by getFile
+type FileInfo struct {
+	FileId       string `json:"file_id"`
+	FileUniqueId string `json:"file_unique_id"`
+	FileSize     int64  `json:"file_size"`
+	FilePath     string `json:"file_path"`
+}
+
+// GetFileInfo resolves a file_id into its path and stable file_unique_id
+func (h *TelegramAPI) GetFileInfo(ctx context.Context, fileId string) (*FileInfo, error) {
+	raw, err := h.callMethod(ctx, "getFile", map[string]string{
+		"file_id": fileId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var info FileInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse getFile result: %w", err)
+	}
+
+	log.Printf("📁 GetFileInfo successful: %s (unique: %s, size: %d bytes)", info.FilePath, info.FileUniqueId, info.FileSize)
+	return &info, nil
+}
+
+func (h *TelegramAPI) DownloadFileWithContext(ctx context.Context, filePath string) ([]byte, string, error) {
+	body, contentType, _, err := h.OpenFileWithContext(ctx, filePath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer body.Close()
+
+	resBody, err := io.ReadAll(body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read response: %w", err)
+	}
+	return resBody, contentType, nil
+}
+
+// OpenFileWithContext starts a file download and returns its body unread. A file over
+// the download size cap fails with ErrFileTooLarge, up front or when the body reads past
+// the cap. The caller closes the body; size is -1 when it is unknown.
+func (h *TelegramAPI) OpenFileWithContext(ctx context.Context, filePath string) (io.ReadCloser, string, int64, error) {
+	cleanPath := strings.TrimPrefix(filePath, "/")
+
+	directDownload := os.Getenv("TELEGRAM_DIRECT_DOWNLOAD") == "true"
+
+	var reqURL string
+	if directDownload {
+		reqURL = "https://api.telegram.org/file/bot" + h.token + "/" + cleanPath
+	} else {
+		reqURL = getBaseURL() + "/file/" + h.token + "/" + cleanPath
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("request creation failed: %w", err)
+	}
+
+	response, err := h.client.Do(req)
+	if err != nil {
+		return nil, "", 0, h.requestError(ctx, err)
+	}
+
+	if response.StatusCode != 200 {
+		response.Body.Close()
+		return nil, "", 0, fmt.Errorf("download failed (status %d)", response.StatusCode)
+	}
+
+	size := response.ContentLength
+	if size > maxFileDownloadSize {
+		response.Body.Close()
+		return nil, "", 0, fmt.Errorf("%w: %d bytes", ErrFileTooLarge, size)
+	}
+	body := struct {
+		io.Reader
+		io.Closer
+	}{CapReader(response.Body, maxFileDownloadSize, ErrFileTooLarge), response.Body}
+	return body, response.Header.Get("Content-Type"), size, nil
+}
+
+// ErrFileTooLarge is returned for downloads larger than the download size cap
+var ErrFileTooLarge = fmt.Errorf("file is larger than the %d bytes download limit", maxFileDownloadSize)
+
+// CapReader reads at most limit bytes from r and fails with tooLarge if r holds more,
+// instead of silently truncating it
+func CapReader(r io.Reader, limit int64, tooLarge error) io.Reader {
+	return &cappedReader{r: r, remaining: limit, tooLarge: tooLarge}
+}
+
+type cappedReader struct {
+	r         io.Reader
+	remaining int64
+	tooLarge  error
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.remaining <= 0 {
+		// فقط وقتی خطاست که واقعا داده بیشتری باشه
+		if n, _ := c.r.Read(make([]byte, 1)); n > 0 {
+			return 0, c.tooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	return n, err
+}
+
+// MediaGroupItem is a single file inside a sendMediaGroup album
+type MediaGroupItem struct {
+	ContentType string
+	FileName    string
+	Data        []byte
+}
+
+type inputMedia struct {
+	Type  string `json:"type"`
+	Media string `json:"media"`
+}
+
+// mediaGroupType maps a content type to an InputMedia type.
+// Telegram only allows photos and videos to be mixed in one album,
+// audio and documents must be grouped with their own kind.
+func mediaGroupType(contentType string) string {
+	switch {
+	case strings.Contains(contentType, "image"):
+		return "photo"
+	case strings.Contains(contentType, "video"):
+		return "video"
+	case strings.Contains(contentType, "audio"):
+		return "audio"
+	default:
+		return "document"
+	}
+}
+
+// SendMediaGroup uploads 2-10 files as a single album and returns the ids in album order
+func (h *TelegramAPI) SendMediaGroup(items []MediaGroupItem, chatId string) ([]UploadResult, error) {
+	if len(items) < 2 || len(items) > 10 {
+		return nil, fmt.Errorf("media group must contain 2-10 items, got %d", len(items))
+	}
+
+	// اگه آلبوم ترکیبی از عکس/ویدیو با فایل دیگه باشه، همه رو document بفرست
+	types := make([]string, len(items))
+	visual := 0
+	for i, item := range items {
+		types[i] = mediaGroupType(item.ContentType)
+		if types[i] == "photo" || types[i] == "video" {
+			visual++
+		}
+	}
+	if visual != 0 && visual != len(items) {
+		for i := range types {
+			types[i] = "document"
+		}
+	} else if visual == 0 {
+		for i := range types {
+			if types[i] != types[0] {
+				for j := range types {
+					types[j] = "document"
+				}
+				break
+			}
+		}
+	}
+
+	body := &bytes.Buffer{}
+	mwriter := multipart.NewWriter(body)
+
+	if err := mwriter.WriteField("chat_id", chatId); err != nil {
+		return nil, fmt.Errorf("failed to write chat_id: %w", err)
+	}
+
+	media := make([]inputMedia, len(items))
+	for i := range items {
+		media[i] = inputMedia{Type: types[i], Media: fmt.Sprintf("attach://file%d", i)}
+	}
+	mediaJson, err := json.Marshal(media)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode media: %w", err)
+	}
+	if err := mwriter.WriteField("media", string(mediaJson)); err != nil {
+		return nil, fmt.Errorf("failed to write media: %w", err)
+	}
+
+	for i, item := range items {
+		fileWriter, err := mwriter.CreateFormFile(fmt.Sprintf("file%d", i), item.FileName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create form file: %w", err)
+		}
+		if _, err := fileWriter.Write(item.Data); err != nil {
+			return nil, fmt.Errorf("failed to write file data: %w", err)
+		}
+	}
+
+	if err := mwriter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close multipart writer: %w", err)
+	}
+
+	reqUrl := getBaseURL() + "/bot" + h.token + "/sendMediaGroup"
+	req, err := http.NewRequest("POST", reqUrl, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", mwriter.FormDataContentType())
+
+	response, err := h.client.Do(req)
+	if err != nil {
+		return nil, h.requestError(context.Background(), err)
+	}
+
+	defer response.Body.Close()
+	resBody, err := io.ReadAll(io.LimitReader(response.Body, maxAPIResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if response.StatusCode != 200 {
+		return nil, parseAPIError("sendMediaGroup", response.StatusCode, resBody)
+	}
+
+	var tgResponse struct {
+		Ok          bool                     `json:"ok"`
+		Description string                   `json:"description"`
+		Result      []map[string]interface{} `json:"result"`
+	}
+	if err := json.Unmarshal(resBody, &tgResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if !tgResponse.Ok {
+		return nil, parseAPIError("sendMediaGroup", response.StatusCode, resBody)
+	}
+	if len(tgResponse.Result) != len(items) {
+		return nil, fmt.Errorf("unexpected media group result count: %d (sent %d)", len(tgResponse.Result), len(items))
+	}
+
+	results := make([]UploadResult, len(items))
+	for i, message := range tgResponse.Result {
+		uploaded, err := parseUploadedMessage(message, types[i])
+		if err != nil {
+			return nil, fmt.Errorf("album item %d: %w", i, err)
+		}
+		if uploaded.MimeType == "" {
+			uploaded.MimeType = items[i].ContentType
 		}
-		fileID, _ = fileInfo["file_id"].(string)
-	} else if formField == "photo" {
-		photos, ok := result["photo"].([]interface{})
+		results[i] = *uploaded
+	}
+
+	log.Printf("📤 Media group upload successful: %d items", len(results))
+	return results, nil
+}
+
+// UploadResult describes the message and file Telegram created for an upload
+type UploadResult struct {
+	FileId       string `json:"fileId"`
+	FileUniqueId string `json:"fileUniqueId"`
+	MessageId    int64  `json:"messageId"`
+	ChatId       int64  `json:"chatId"`
+	FileSize     int64  `json:"fileSize"`
+	MimeType     string `json:"mimeType"`
+}
+
+// parseUploadedMessage reads the ids of the given media kind from a Message object
+func parseUploadedMessage(message map[string]interface{}, kind string) (*UploadResult, error) {
+	var fileInfo map[string]interface{}
+	if kind == "photo" {
+		photos, ok := message["photo"].([]interface{})
 		if !ok || len(photos) == 0 {
-			return "", errors.New("missing photo array in response")
+			return nil, errors.New("missing photo array in response")
 		}
 		// گرفتن بزرگترین عکس (آخرین در آرایه)
 		lastPhoto, ok := photos[len(photos)-1].(map[string]interface{})
 		if !ok {
-			return "", errors.New("invalid photo format in response")
+			return nil, errors.New("invalid photo format in response")
+		}
+		fileInfo = lastPhoto
+	} else {
+		info, ok := message[kind].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("missing %s in response", kind)
 		}
-		fileID, _ = lastPhoto["file_id"].(string)
+		fileInfo = info
 	}
 
-	if fileID == "" {
-		return "", errors.New("file_id not found in response")
+	result := &UploadResult{}
+	result.FileId, _ = fileInfo["file_id"].(string)
+	result.FileUniqueId, _ = fileInfo["file_unique_id"].(string)
+	result.MimeType, _ = fileInfo["mime_type"].(string)
+	if fileSize, ok := fileInfo["file_size"].(float64); ok {
+		result.FileSize = int64(fileSize)
+	}
+	if messageId, ok := message["message_id"].(float64); ok {
+		result.MessageId = int64(messageId)
+	}
+	if chat, ok := message["chat"].(map[string]interface{}); ok {
+		if chatId, ok := chat["id"].(float64); ok {
+			result.ChatId = int64(chatId)
+		}
+	}
+	if kind == "photo" && result.MimeType == "" {
+		result.MimeType = "image/jpeg"
 	}
 
-	log.Printf("📤 Upload successful: %s (FileID: %s)", fileName, fileID)
-	return fileID, nil
+	if result.FileId == "" {
+		return nil, errors.New("file_id not found in response")
+	}
+	return result, nil
 }
 
-// متدهای با Context support
-func (h *TelegramAPI) GetFileWithContext(ctx context.Context, fileId string) (string, error) {
-	bodyRaw := map[string]string{
-		"file_id": fileId,
+type apiResponse struct {
+	Ok          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	Description string          `json:"description"`
+	ErrorCode   int             `json:"error_code"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// ErrNotSent marks a request that failed before it reached Telegram
+var ErrNotSent = errors.New("request was not sent")
+
+// IsNotSent reports whether err happened before the request was sent (DNS lookup or
+// connecting), so sending it again cannot post a message twice. Timeouts and broken
+// connections are not: Telegram may already have handled the request.
+func IsNotSent(err error) bool {
+	if errors.Is(err, ErrNotSent) {
+		return true
 	}
-	reqURL := getBaseURL() + "/bot" + h.token + "/getFile"
-	body, err := json.Marshal(bodyRaw)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "proxyconnect")
+}
+
+// APIError is a failed Bot API call with the status Telegram reported
+type APIError struct {
+	Method      string
+	StatusCode  int
+	ErrorCode   int
+	Description string
+	RetryAfter  int
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("telegram %s failed (status %d): %s", e.Method, e.StatusCode, e.Description)
+}
+
+// IsUnauthorized reports whether the token was rejected (revoked or malformed)
+func (e *APIError) IsUnauthorized() bool {
+	return e.StatusCode == 401 || e.ErrorCode == 401 || e.StatusCode == 404 && e.Method == "getMe"
+}
+
+// IsRateLimited reports whether Telegram asked to slow down
+func (e *APIError) IsRateLimited() bool {
+	return e.StatusCode == 429 || e.ErrorCode == 429
+}
+
+// parseAPIError builds an APIError from a failed Bot API response body
+func parseAPIError(method string, statusCode int, body []byte) *APIError {
+	var result apiResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return &APIError{Method: method, StatusCode: statusCode, Description: string(body)}
+	}
+	return &APIError{
+		Method:      method,
+		StatusCode:  statusCode,
+		ErrorCode:   result.ErrorCode,
+		Description: result.Description,
+		RetryAfter:  result.Parameters.RetryAfter,
+	}
+}
+
+// callMethod posts a JSON payload to a Bot API method and returns the raw result
+func (h *TelegramAPI) callMethod(ctx context.Context, method string, payload interface{}) (json.RawMessage, error) {
+	body, err := json.Marshal(payload)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
+	reqURL := getBaseURL() + "/bot" + h.token + "/" + method
 	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, bytes.NewBuffer(body))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	req.Header.Set("Content-Type", ContentType)
 
 	response, err := h.client.Do(req)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("%s %w", method, h.requestError(ctx, err))
 	}
 
 	defer response.Body.Close()
-	resBody, readErr := io.ReadAll(io.LimitReader(response.Body, maxAPIResponseSize))
-	if readErr != nil {
-		return "", fmt.Errorf("failed to read GetFileWithContext response: %w", readErr)
-	}
-	if response.StatusCode != 200 {
-		return "", errors.New("telegram failed " + string(resBody))
+	resBody, err := io.ReadAll(io.LimitReader(response.Body, maxAPIResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s response: %w", method, err)
 	}
 
-	var result GetFileResponse
+	var result apiResponse
 	if err := json.Unmarshal(resBody, &result); err != nil {
-		return "", err
+		if response.StatusCode != 200 {
+			return nil, &APIError{Method: method, StatusCode: response.StatusCode, Description: string(resBody)}
+		}
+		return nil, fmt.Errorf("failed to parse %s response: %w", method, err)
 	}
 
-	if !result.Ok {
-		return "", fmt.Errorf("telegram GetFile failed: %s", result.Description)
+	if response.StatusCode != 200 || !result.Ok {
+		return nil, &APIError{
+			Method:      method,
+			StatusCode:  response.StatusCode,
+			ErrorCode:   result.ErrorCode,
+			Description: result.Description,
+			RetryAfter:  result.Parameters.RetryAfter,
+		}
 	}
 
-	log.Printf("📁 GetFileWithContext successful: %s (size: %d bytes)", result.Result.FilePath, result.Result.FileSize)
-	return result.Result.FilePath, nil
+	return result.Result, nil
 }
 
-func (h *TelegramAPI) DownloadFileWithContext(ctx context.Context, filePath string) ([]byte, string, error) {
-	cleanPath := strings.TrimPrefix(filePath, "/")
+// SendFileById posts a file this bot already knows to chatId without uploading it again.
+// file_ids are bound to the bot that received them, so fileId must come from this bot.
+func (h *TelegramAPI) SendFileById(ctx context.Context, contentType, fileId, chatId string) (*UploadResult, error) {
+	kind := mediaKind(contentType)
+	method := "send" + strings.ToUpper(kind[:1]) + kind[1:]
 
-	directDownload := os.Getenv("TELEGRAM_DIRECT_DOWNLOAD") == "true"
+	raw, err := h.callMethod(ctx, method, map[string]interface{}{
+		"chat_id": chatId,
+		kind:      fileId,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	var reqURL string
-	if directDownload {
-		reqURL = "https://api.telegram.org/file/bot" + h.token + "/" + cleanPath
-	} else {
-		reqURL = getBaseURL() + "/file/" + h.token + "/" + cleanPath
+	var message map[string]interface{}
+	if err := json.Unmarshal(raw, &message); err != nil {
+		return nil, fmt.Errorf("failed to parse %s result: %w", method, err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	sent, err := parseUploadedMessage(message, kind)
 	if err != nil {
-		return nil, "", fmt.Errorf("request creation failed: %w", err)
+		return nil, err
+	}
+	if sent.MimeType == "" {
+		sent.MimeType = contentType
 	}
 
-	response, err := h.client.Do(req)
+	log.Printf("📨 Sent FileID %s to chat %s (MessageID: %d)", fileId, chatId, sent.MessageId)
+	return sent, nil
+}
+
+// DeleteMessage removes a message from a chat; files sent in it keep their file_id
+func (h *TelegramAPI) DeleteMessage(ctx context.Context, chatId string, messageId int64) error {
+	_, err := h.callMethod(ctx, "deleteMessage", map[string]interface{}{
+		"chat_id":    chatId,
+		"message_id": messageId,
+	})
 	if err != nil {
-		return nil, "", fmt.Errorf("request failed: %w", err)
+		return err
 	}
-	defer response.Body.Close()
 
-	if response.StatusCode == 200 {
-		resBody, err := io.ReadAll(io.LimitReader(response.Body, maxFileDownloadSize))
-		if err != nil {
-			return nil, "", fmt.Errorf("failed to read response: %w", err)
+	log.Printf("🗑️ Deleted message %d from chat %s", messageId, chatId)
+	return nil
+}
+
+// EditMessageCaption replaces the caption of a media message
+func (h *TelegramAPI) EditMessageCaption(ctx context.Context, chatId string, messageId int64, caption string) error {
+	_, err := h.callMethod(ctx, "editMessageCaption", map[string]interface{}{
+		"chat_id":    chatId,
+		"message_id": messageId,
+		"caption":    caption,
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("✏️ Edited caption of message %d in chat %s", messageId, chatId)
+	return nil
+}
+
+// BotUser is the identity returned by getMe
+type BotUser struct {
+	Id        int64  `json:"id"`
+	IsBot     bool   `json:"is_bot"`
+	FirstName string `json:"first_name"`
+	Username  string `json:"username"`
+}
+
+// GetMe returns the identity of the bot owning the token
+func (h *TelegramAPI) GetMe(ctx context.Context) (*BotUser, error) {
+	raw, err := h.callMethod(ctx, "getMe", map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	var user BotUser
+	if err := json.Unmarshal(raw, &user); err != nil {
+		return nil, fmt.Errorf("failed to parse getMe result: %w", err)
+	}
+
+	return &user, nil
+}
+
+// Update is an incoming webhook update; only the fields we archive are decoded
+type Update struct {
+	UpdateId          int64    `json:"update_id"`
+	Message           *Message `json:"message,omitempty"`
+	EditedMessage     *Message `json:"edited_message,omitempty"`
+	ChannelPost       *Message `json:"channel_post,omitempty"`
+	EditedChannelPost *Message `json:"edited_channel_post,omitempty"`
+}
+
+// Messages returns every message carried by the update
+func (u *Update) Messages() []*Message {
+	var messages []*Message
+	for _, message := range []*Message{u.Message, u.EditedMessage, u.ChannelPost, u.EditedChannelPost} {
+		if message != nil {
+			messages = append(messages, message)
 		}
-		return resBody, response.Header.Get("Content-Type"), nil
 	}
+	return messages
+}
 
-	return nil, "", fmt.Errorf("download failed (status %d)", response.StatusCode)
-}
\ No newline at end of file
+type Chat struct {
+	Id       int64  `json:"id"`
+	Type     string `json:"type"`
+	Title    string `json:"title,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+type PhotoSize struct {
+	FileId       string `json:"file_id"`
+	FileUniqueId string `json:"file_unique_id"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	FileSize     int64  `json:"file_size,omitempty"`
+}
+
+// File is the common part of video, document, audio and animation objects
+type File struct {
+	FileId       string `json:"file_id"`
+	FileUniqueId string `json:"file_unique_id"`
+	FileName     string `json:"file_name,omitempty"`
+	MimeType     string `json:"mime_type,omitempty"`
+	FileSize     int64  `json:"file_size,omitempty"`
+}
+
+type Message struct {
+	MessageId    int64       `json:"message_id"`
+	Date         int64       `json:"date"`
+	Chat         Chat        `json:"chat"`
+	MediaGroupId string      `json:"media_group_id,omitempty"`
+	Caption      string      `json:"caption,omitempty"`
+	Photo        []PhotoSize `json:"photo,omitempty"`
+	Video        *File       `json:"video,omitempty"`
+	Document     *File       `json:"document,omitempty"`
+}
+
+// MessageFile is a downloadable file found in a message
+type MessageFile struct {
+	Kind string
+	File
+}
+
+// Files returns the photo (largest size), video and document of the message
+func (m *Message) Files() []MessageFile {
+	var files []MessageFile
+	if len(m.Photo) > 0 {
+		// گرفتن بزرگترین عکس (آخرین در آرایه)
+		largest := m.Photo[len(m.Photo)-1]
+		files = append(files, MessageFile{Kind: "photo", File: File{
+			FileId:       largest.FileId,
+			FileUniqueId: largest.FileUniqueId,
+			MimeType:     "image/jpeg",
+			FileSize:     largest.FileSize,
+		}})
+	}
+	if m.Video != nil {
+		files = append(files, MessageFile{Kind: "video", File: *m.Video})
+	}
+	if m.Document != nil {
+		files = append(files, MessageFile{Kind: "document", File: *m.Document})
+	}
+	return files
+}
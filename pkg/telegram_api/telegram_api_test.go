@@ -0,0 +1,138 @@
+package telegram_api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOpenFileWithContextSizeCap(t *testing.T) {
+	chunk := bytes.Repeat([]byte("x"), 1<<20)
+
+	tests := []struct {
+		name string
+		size int
+		// chunked hides the size, so the cap can only trip while reading
+		chunked bool
+		wantErr bool
+	}{
+		{"small file", 1000, false, false},
+		{"exactly the cap", maxFileDownloadSize, true, false},
+		{"declared over the cap", maxFileDownloadSize + 1, false, true},
+		{"streamed over the cap", maxFileDownloadSize + 1, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !tt.chunked {
+					w.Header().Set("Content-Length", strconv.Itoa(tt.size))
+				}
+				for left := tt.size; left > 0; left -= len(chunk) {
+					if _, err := w.Write(chunk[:min(left, len(chunk))]); err != nil {
+						return
+					}
+					if tt.chunked {
+						w.(http.Flusher).Flush()
+					}
+				}
+			}))
+			defer server.Close()
+			t.Setenv("TELEGRAM_API_BASE_URL", server.URL)
+			t.Setenv("TELEGRAM_DIRECT_DOWNLOAD", "")
+
+			body, _, _, err := New("123:TOKEN").OpenFileWithContext(context.Background(), "documents/file.bin")
+			if err == nil {
+				var n int64
+				n, err = io.Copy(io.Discard, body)
+				body.Close()
+				if err == nil && n != int64(tt.size) {
+					t.Fatalf("read %d bytes, want %d", n, tt.size)
+				}
+			}
+
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrFileTooLarge) {
+				t.Fatalf("error = %v, want ErrFileTooLarge", err)
+			}
+		})
+	}
+}
+
+func TestTransportErrorsHideToken(t *testing.T) {
+	const token = "123:SECRETTOKEN"
+
+	// refused: nothing listens, so the request never left; dropped: the connection
+	// closes after the request was sent
+	refused := httptest.NewServer(http.NotFoundHandler())
+	refusedURL := refused.URL
+	refused.Close()
+	dropped := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer dropped.Close()
+
+	calls := map[string]func(api *TelegramAPI) error{
+		"UploadFile": func(api *TelegramAPI) error {
+			_, err := api.UploadFile("text/plain", "a.txt", []byte("hi"), "-100")
+			return err
+		},
+		"SendMediaGroup": func(api *TelegramAPI) error {
+			item := MediaGroupItem{ContentType: "image/jpeg", FileName: "a.jpg", Data: []byte("x")}
+			_, err := api.SendMediaGroup([]MediaGroupItem{item, item}, "-100")
+			return err
+		},
+		"DownloadFile": func(api *TelegramAPI) error {
+			_, _, err := api.DownloadFile("documents/a.txt")
+			return err
+		},
+		"OpenFileWithContext": func(api *TelegramAPI) error {
+			_, _, _, err := api.OpenFileWithContext(context.Background(), "documents/a.txt")
+			return err
+		},
+		"GetFileInfo": func(api *TelegramAPI) error {
+			_, err := api.GetFileInfo(context.Background(), "F1")
+			return err
+		},
+	}
+
+	tests := []struct {
+		name        string
+		baseURL     string
+		wantNotSent bool
+	}{
+		{"connection refused", refusedURL, true},
+		{"connection dropped", dropped.URL, false},
+	}
+
+	for _, tt := range tests {
+		for name, call := range calls {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				t.Setenv("TELEGRAM_API_BASE_URL", tt.baseURL)
+				t.Setenv("TELEGRAM_DIRECT_DOWNLOAD", "")
+
+				err := call(New(token))
+				if err == nil {
+					t.Fatal("call succeeded against a broken server")
+				}
+				if strings.Contains(err.Error(), "SECRETTOKEN") {
+					t.Errorf("error leaks the bot token: %v", err)
+				}
+				if got := IsNotSent(err); got != tt.wantNotSent {
+					t.Errorf("IsNotSent = %v, want %v (error: %v)", got, tt.wantNotSent, err)
+				}
+			})
+		}
+	}
+}
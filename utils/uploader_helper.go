@@ -4,10 +4,14 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"slices"
+	"sync"
 	"time"
 )
 
-var ValidBuckets = []string{"instagram", "telegram", "influencer", "tracker"}
+var (
+	validBucketsMu sync.RWMutex
+	validBuckets   = []string{"instagram", "telegram", "influencer", "tracker"}
+)
 
 var (
 	ImageFileTypes = map[string]string{
@@ -41,7 +45,16 @@ func CreateFileID(fileBuffer []byte) ([]byte, error) {
 }
 
 func IsValidBucket(name string) bool {
-	return slices.Contains(ValidBuckets, name)
+	validBucketsMu.RLock()
+	defer validBucketsMu.RUnlock()
+	return slices.Contains(validBuckets, name)
+}
+
+// SetValidBuckets replaces the buckets accepted by IsValidBucket (on config load and reload)
+func SetValidBuckets(buckets []string) {
+	validBucketsMu.Lock()
+	defer validBucketsMu.Unlock()
+	validBuckets = slices.Clone(buckets)
 }
 
 func CreateFilePath(fileName string, ext string) string {